@@ -0,0 +1,543 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// Wildcard characters understood by the AHRI index
+const (
+	// WildcardChar matches exactly one character at its position
+	WildcardChar = '*'
+	// keySeparator joins the outdoor, indoor and furnace models into one key.
+	// Wildcards never match across it.
+	keySeparator = '|'
+	// headSeparator joins the indoor heads of a multi-split system (see headsKey).
+	// Wildcards never match across it either.
+	headSeparator = '+'
+)
+
+/*
+AHRIIndex answers "is this equipment combination certified" without expanding
+wildcards up front. Keys with no wildcards go in a plain map; keys containing
+wildcards go in a trie whose wildcard edges are resolved at lookup time, so
+memory grows with the number of AHRI records rather than the number of
+characters a wildcard could stand for.
+
+Records are partitioned by manufacturer and AHRI program when the export lists
+them, so truncated models from different manufacturers or programs never collide.
+Records without a manufacturer or program apply to every brand or system type.
+*/
+type AHRIIndex struct {
+	partitions map[ahriQualifier]*ahriPartition
+
+	// searchOrder lists, per brand and system type, the partitions a lookup searches,
+	// most specific first. Brands with no partition of their own use the "" entry.
+	searchOrder map[ahriQualifier][]*ahriPartition
+
+	// programTypes maps each AHRI program to the system types it certifies
+	programTypes map[string]map[string]bool
+
+	// unknownPrograms are the AHRI programs that name no system type and aren't
+	// mapped to any, each logged once; their records apply to every system type
+	unknownPrograms map[string]bool
+
+	// aliases maps AHRI manufacturers to the further equipment brands they certify
+	aliases map[string]map[string]bool
+
+	// multiWildcard, when non-zero, matches any run of zero or more characters
+	multiWildcard byte
+
+	// revisionRules decide which part of a model number is compared (see modelKey)
+	revisionRules []data_structures.RevisionRule
+
+	// replaced holds the supersessions in effect, by new model (see predecessors)
+	replaced map[string][]data_structures.Supersession
+
+	// wildcardHeads is set when a multi-split record has wildcards in its heads,
+	// so lookups must try every order of a combination's heads (see comboKeys)
+	wildcardHeads bool
+}
+
+// ahriQualifier identifies a partition by manufacturer and program (or, in
+// searchOrder, a lookup by brand and system type). Values are lower case.
+type ahriQualifier struct {
+	manufacturer string
+	program      string
+}
+
+type ahriPartition struct {
+	qualifier ahriQualifier
+
+	keys  *ahriKeySet // outdoor|indoor|furnace keys
+	pairs *ahriKeySet // outdoor|indoor keys of records with a furnace, for any furnace lookups
+}
+
+// ahriKeySet holds one set of keys: plain keys in a map, wildcard keys in a trie
+type ahriKeySet struct {
+	exact        map[string][]data_structures.AHRIRecord
+	trie         *ahriTrieNode
+	wildcardKeys int
+}
+
+func newAHRIKeySet() *ahriKeySet {
+	return &ahriKeySet{
+		exact: make(map[string][]data_structures.AHRIRecord),
+		trie:  newAHRITrieNode(),
+	}
+}
+
+type ahriTrieNode struct {
+	children map[byte]*ahriTrieNode
+	anyChar  *ahriTrieNode // single character wildcard edge
+	anyRun   *ahriTrieNode // multi character wildcard edge
+
+	records []data_structures.AHRIRecord // records whose key ends at this node
+}
+
+func newAHRITrieNode() *ahriTrieNode {
+	return &ahriTrieNode{children: make(map[byte]*ahriTrieNode)}
+}
+
+/*
+BuildAHRIIndex indexes the AHRI records by model key (see modelKey and
+AHRIIndexOptions for the wildcard and program settings). Every record is kept: a
+key listed by several AHRI records (different ratings, blower settings, etc.)
+returns all of them in input order.
+
+A record's program should be one of the system type names (e.g. "heat pump & furnace")
+or output values, or be mapped to system type names in opts.Programs. Records of any
+other program are logged once per program and indexed as if they had no program.
+A record's manufacturer certifies the brand of the same name, ignoring case, and
+any brands opts.ManufacturerAliases lists for it.
+*/
+func BuildAHRIIndex(ahriList []data_structures.AHRIRecord, opts data_structures.AHRIIndexOptions) (*AHRIIndex, error) {
+	index := &AHRIIndex{
+		partitions:      make(map[ahriQualifier]*ahriPartition),
+		programTypes:    make(map[string]map[string]bool),
+		unknownPrograms: make(map[string]bool),
+		aliases:         make(map[string]map[string]bool),
+		multiWildcard:   opts.MultiWildcard,
+		revisionRules:   opts.RevisionRules,
+		replaced:        buildSupersessions(opts.Supersessions, opts.AsOf),
+	}
+
+	for manufacturer, brands := range opts.ManufacturerAliases {
+		manufacturer = qualifierValue(manufacturer)
+		if index.aliases[manufacturer] == nil {
+			index.aliases[manufacturer] = make(map[string]bool)
+		}
+		for _, brand := range brands {
+			index.aliases[manufacturer][qualifierValue(brand)] = true
+		}
+	}
+
+	for program, names := range opts.Programs {
+		types := make(map[string]bool)
+		for _, name := range names {
+			sysType, known := findSystemType(name)
+			if !known {
+				return nil, fmt.Errorf("AHRI program %s maps to unknown system type %q", program, name)
+			}
+			types[sysType.Value] = true
+		}
+		index.programTypes[qualifierValue(program)] = types
+	}
+
+	for _, record := range ahriList {
+		qualifier := ahriQualifier{
+			manufacturer: qualifierValue(record.Manufacturer),
+			program:      qualifierValue(record.Program),
+		}
+		if !index.resolveProgram(qualifier.program) {
+			if !index.unknownPrograms[qualifier.program] {
+				index.unknownPrograms[qualifier.program] = true
+				log.Printf("Unknown AHRI program %q (first seen on AHRI record %s): add it to ahriPrograms; its records are used for every system type",
+					record.Program, record.AHRINumber)
+			}
+			qualifier.program = ""
+		}
+
+		partition, exists := index.partitions[qualifier]
+		if !exists {
+			partition = &ahriPartition{
+				qualifier: qualifier,
+				keys:      newAHRIKeySet(),
+				pairs:     newAHRIKeySet(),
+			}
+			index.partitions[qualifier] = partition
+		}
+
+		furnace := NormalizeString(record.Furnace)
+		indoorUnit := NormalizeString(record.IndoorUnit)
+		outdoorUnit := NormalizeString(record.OutdoorUnit)
+
+		outdoorKey := index.modelKey(ComponentOutdoorUnit, outdoorUnit)
+		indoorKey := index.modelKey(ComponentIndoorUnit, indoorUnit)
+		furnaceKey := index.modelKey(ComponentFurnace, furnace)
+
+		// Multi-split records list every head the outdoor unit feeds
+		if len(record.IndoorHeads) > 0 {
+			heads := make([]data_structures.Equipment, len(record.IndoorHeads))
+			for i, head := range record.IndoorHeads {
+				heads[i] = NormalizeString(head)
+			}
+			indoorKey = index.headsKey(heads)
+			index.wildcardHeads = index.wildcardHeads || (len(heads) > 1 && index.hasWildcard(indoorKey))
+		}
+
+		index.add(partition.keys, ahriKey(outdoorKey, indoorKey, furnaceKey), record)
+		if furnaceKey != "" {
+			index.add(partition.pairs, ahriPairKey(outdoorKey, indoorKey), record)
+		}
+	}
+
+	index.buildSearchOrder()
+
+	return index, nil
+}
+
+func qualifierValue(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// resolveProgram records which system types an AHRI program certifies, accepting
+// system type names and output values as programs in their own right. It reports
+// false for a program it can't resolve.
+func (index *AHRIIndex) resolveProgram(program string) bool {
+	if program == "" {
+		return true
+	}
+	if _, resolved := index.programTypes[program]; resolved {
+		return true
+	}
+	for _, sysType := range systemTypeRegistry {
+		if program == sysType.Name || program == sysType.Value {
+			index.programTypes[program] = map[string]bool{sysType.Value: true}
+			return true
+		}
+	}
+	return false
+}
+
+// certifiesBrand reports whether records of the AHRI manufacturer certify the brand
+// (both lower case). Records without a manufacturer certify every brand.
+func (index *AHRIIndex) certifiesBrand(manufacturer string, brand string) bool {
+	return manufacturer == "" || manufacturer == brand || index.aliases[manufacturer][brand]
+}
+
+// accepts reports whether records in the partition can certify a combination of
+// the brand and system type. An empty brand only accepts unqualified manufacturers.
+func (index *AHRIIndex) accepts(partition *ahriPartition, brand string, sysType string) bool {
+	q := partition.qualifier
+	if q.manufacturer != "" && (brand == "" || !index.certifiesBrand(q.manufacturer, brand)) {
+		return false
+	}
+	return q.program == "" || index.programTypes[q.program][sysType]
+}
+
+// buildSearchOrder precomputes the partitions each brand and system type searches
+func (index *AHRIIndex) buildSearchOrder() {
+	partitions := make([]*ahriPartition, 0, len(index.partitions))
+	brands := map[string]bool{"": true}
+	for qualifier, partition := range index.partitions {
+		partitions = append(partitions, partition)
+		brands[qualifier.manufacturer] = true
+		for alias := range index.aliases[qualifier.manufacturer] {
+			brands[alias] = true
+		}
+	}
+
+	// Manufacturer specific before generic, program specific before generic, then by name
+	sort.Slice(partitions, func(i, j int) bool {
+		a, b := partitions[i].qualifier, partitions[j].qualifier
+		if (a.manufacturer == "") != (b.manufacturer == "") {
+			return a.manufacturer != ""
+		}
+		if (a.program == "") != (b.program == "") {
+			return a.program != ""
+		}
+		if a.manufacturer != b.manufacturer {
+			return a.manufacturer < b.manufacturer
+		}
+		return a.program < b.program
+	})
+
+	index.searchOrder = make(map[ahriQualifier][]*ahriPartition)
+	for brand := range brands {
+		for _, sysType := range systemTypeRegistry {
+			qualifier := ahriQualifier{manufacturer: brand, program: sysType.Value}
+			for _, partition := range partitions {
+				if index.accepts(partition, brand, sysType.Value) {
+					index.searchOrder[qualifier] = append(index.searchOrder[qualifier], partition)
+				}
+			}
+		}
+	}
+}
+
+// searchPartitions returns the partitions a lookup for the brand and system type searches
+func (index *AHRIIndex) searchPartitions(brand string, sysType string) []*ahriPartition {
+	if partitions, exists := index.searchOrder[ahriQualifier{manufacturer: qualifierValue(brand), program: sysType}]; exists {
+		return partitions
+	}
+	return index.searchOrder[ahriQualifier{program: sysType}]
+}
+
+/*
+UnpartitionedBrands returns the brands, in the order given, that no manufacturer in
+the AHRI data certifies by name or alias. Their combinations are only certified by
+records without a manufacturer, which usually means the manufacturer is spelled
+differently in the AHRI export and needs an alias. It is empty when the export has
+no manufacturer column.
+*/
+func (index *AHRIIndex) UnpartitionedBrands(brands []string) []string {
+	manufacturers := []string{}
+	for qualifier := range index.partitions {
+		if qualifier.manufacturer != "" {
+			manufacturers = append(manufacturers, qualifier.manufacturer)
+		}
+	}
+
+	unmatched := []string{}
+	if len(manufacturers) == 0 {
+		return unmatched
+	}
+	for _, brand := range brands {
+		certified := slices.ContainsFunc(manufacturers, func(manufacturer string) bool {
+			return index.certifiesBrand(manufacturer, qualifierValue(brand))
+		})
+		if !certified {
+			unmatched = append(unmatched, brand)
+		}
+	}
+	return unmatched
+}
+
+func ahriKey(outdoor, indoor, furnace string) string {
+	return outdoor + string(keySeparator) + indoor + string(keySeparator) + furnace
+}
+
+func ahriPairKey(outdoor, indoor string) string {
+	return outdoor + string(keySeparator) + indoor
+}
+
+func (index *AHRIIndex) hasWildcard(key string) bool {
+	if strings.IndexByte(key, WildcardChar) != -1 {
+		return true
+	}
+	return index.multiWildcard != 0 && strings.IndexByte(key, index.multiWildcard) != -1
+}
+
+func (index *AHRIIndex) add(set *ahriKeySet, key string, record data_structures.AHRIRecord) {
+	if !index.hasWildcard(key) {
+		set.exact[key] = append(set.exact[key], record)
+		return
+	}
+
+	node := set.trie
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c == WildcardChar:
+			if node.anyChar == nil {
+				node.anyChar = newAHRITrieNode()
+			}
+			node = node.anyChar
+		case index.multiWildcard != 0 && c == index.multiWildcard:
+			if node.anyRun == nil {
+				node.anyRun = newAHRITrieNode()
+			}
+			node = node.anyRun
+		default:
+			child, exists := node.children[c]
+			if !exists {
+				child = newAHRITrieNode()
+				node.children[c] = child
+			}
+			node = child
+		}
+	}
+
+	if len(node.records) == 0 {
+		set.wildcardKeys++
+	}
+	node.records = append(node.records, record)
+}
+
+// Len returns the number of distinct keys in the index (wildcard keys count once per partition).
+func (index *AHRIIndex) Len() int {
+	total := 0
+	for _, partition := range index.partitions {
+		total += len(partition.keys.exact) + partition.keys.wildcardKeys
+	}
+	return total
+}
+
+/*
+Lookup returns every record certifying any of the keys for a combination of the brand
+and system type (a ComponentKey SystemType value): exact records first, then records
+whose wildcards match, each in input order and from the most specific partition
+first. A record reached more than once, or an AHRI number listed twice for the
+same key, is only returned once. Combinations normally have a single key; see
+comboKeys for the exception.
+*/
+func (index *AHRIIndex) Lookup(brand string, sysType string, keys ...string) ([]data_structures.AHRIRecord, bool) {
+	return lookupKeySets(index.searchPartitions(brand, sysType), func(partition *ahriPartition) *ahriKeySet {
+		return partition.keys
+	}, keys)
+}
+
+/*
+LookupAnyFurnace returns the records certifying the outdoor and indoor unit models
+with some furnace, whatever the furnace, in the same order as Lookup. Records without
+a furnace are not included; look those up with an empty furnace in Lookup.
+*/
+func (index *AHRIIndex) LookupAnyFurnace(brand string, sysType string, outdoor string, indoor string) ([]data_structures.AHRIRecord, bool) {
+	return lookupKeySets(index.searchPartitions(brand, sysType), func(partition *ahriPartition) *ahriKeySet {
+		return partition.pairs
+	}, []string{ahriPairKey(outdoor, indoor)})
+}
+
+// lookupKeySets looks the keys up in one key set of each partition
+func lookupKeySets(partitions []*ahriPartition, keySet func(*ahriPartition) *ahriKeySet, keys []string) ([]data_structures.AHRIRecord, bool) {
+	matched := []data_structures.AHRIRecord{}
+	seen := make(map[string]bool)
+
+	addRecords := func(records []data_structures.AHRIRecord) {
+		for _, record := range records {
+			if seen[record.AHRINumber] {
+				continue
+			}
+			seen[record.AHRINumber] = true
+			matched = append(matched, record)
+		}
+	}
+
+	for _, partition := range partitions {
+		for _, key := range keys {
+			addRecords(keySet(partition).exact[key])
+		}
+	}
+
+	for _, partition := range partitions {
+		set := keySet(partition)
+		if set.wildcardKeys == 0 {
+			continue
+		}
+		visited := make(map[*ahriTrieNode]bool)
+		for _, key := range keys {
+			set.trie.match(key, 0, func(node *ahriTrieNode) {
+				if !visited[node] {
+					visited[node] = true
+					addRecords(node.records)
+				}
+			})
+		}
+	}
+
+	return matched, len(matched) > 0
+}
+
+// match walks the trie calling found for every node whose key matches, trying
+// literal characters, then single, then multi character wildcards.
+func (node *ahriTrieNode) match(key string, pos int, found func(*ahriTrieNode)) {
+	if pos == len(key) {
+		if len(node.records) > 0 {
+			found(node)
+		}
+		// A trailing multi character wildcard may match nothing
+		if node.anyRun != nil {
+			node.anyRun.match(key, pos, found)
+		}
+		return
+	}
+
+	c := key[pos]
+
+	if child, exists := node.children[c]; exists {
+		child.match(key, pos+1, found)
+	}
+
+	if c == keySeparator || c == headSeparator {
+		// Wildcards stay within a single model number; a multi character
+		// wildcard can still match nothing before the separator
+		if node.anyRun != nil {
+			node.anyRun.match(key, pos, found)
+		}
+		return
+	}
+
+	if node.anyChar != nil {
+		node.anyChar.match(key, pos+1, found)
+	}
+
+	if node.anyRun != nil {
+		for end := pos; end <= len(key); end++ {
+			node.anyRun.match(key, end, found)
+			if end < len(key) && (key[end] == keySeparator || key[end] == headSeparator) {
+				break
+			}
+		}
+	}
+}
+
+// forEachKey calls fn with every key in the partitions that could certify the
+// system type, and the manufacturer ("" for any) the key is certified for.
+// Wildcard keys are passed with their wildcard characters in place.
+func (index *AHRIIndex) forEachKey(sysType string, fn func(manufacturer string, key string)) {
+	for _, partition := range index.partitions {
+		q := partition.qualifier
+		if q.program != "" && !index.programTypes[q.program][sysType] {
+			continue
+		}
+
+		for key := range partition.keys.exact {
+			fn(q.manufacturer, key)
+		}
+
+		var walk func(node *ahriTrieNode, prefix []byte)
+		walk = func(node *ahriTrieNode, prefix []byte) {
+			if len(node.records) > 0 {
+				fn(q.manufacturer, string(prefix))
+			}
+			for c, child := range node.children {
+				walk(child, append(prefix, c))
+			}
+			if node.anyChar != nil {
+				walk(node.anyChar, append(prefix, WildcardChar))
+			}
+			if node.anyRun != nil {
+				walk(node.anyRun, append(prefix, index.multiWildcard))
+			}
+		}
+		walk(partition.keys.trie, nil)
+	}
+}
+
+// matchModel reports whether a single model number matches a (possibly wildcarded)
+// AHRI model, using the same wildcard rules as Lookup.
+func (index *AHRIIndex) matchModel(pattern string, model string) bool {
+	if pattern == "" {
+		return model == ""
+	}
+
+	c := pattern[0]
+	switch {
+	case c == WildcardChar:
+		return model != "" && index.matchModel(pattern[1:], model[1:])
+	case index.multiWildcard != 0 && c == index.multiWildcard:
+		for end := 0; end <= len(model); end++ {
+			if index.matchModel(pattern[1:], model[end:]) {
+				return true
+			}
+		}
+		return false
+	}
+	return model != "" && model[0] == c && index.matchModel(pattern[1:], model[1:])
+}
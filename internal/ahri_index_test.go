@@ -0,0 +1,348 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// testAHRIIndex builds an AHRI index for a test, failing it on error
+func testAHRIIndex(t testing.TB, records []data_structures.AHRIRecord, multiWildcard byte) *AHRIIndex {
+	t.Helper()
+	index, err := BuildAHRIIndex(records, data_structures.AHRIIndexOptions{MultiWildcard: multiWildcard})
+	if err != nil {
+		t.Fatalf("BuildAHRIIndex: %v", err)
+	}
+	return index
+}
+
+// addTestKey indexes a raw key for every brand and system type
+func addTestKey(index *AHRIIndex, key string, record data_structures.AHRIRecord) {
+	partition, exists := index.partitions[ahriQualifier{}]
+	if !exists {
+		partition = &ahriPartition{keys: newAHRIKeySet(), pairs: newAHRIKeySet()}
+		index.partitions[ahriQualifier{}] = partition
+	}
+	index.add(partition.keys, key, record)
+	index.buildSearchOrder()
+}
+
+func TestAHRIKeyMatching(t *testing.T) {
+	tests := []struct {
+		name          string
+		multiWildcard byte
+		pattern       string // the indexed key
+		key           string // the looked up key
+		want          bool
+	}{
+		{name: "exact key", pattern: "GSXN403010|CAPTA3026B4|", key: "GSXN403010|CAPTA3026B4|", want: true},
+		{name: "exact key differs", pattern: "GSXN403010|CAPTA3026B4|", key: "GSXN403010|CAPTA3026B5|"},
+		{name: "single wildcard", pattern: "GSXN4*3010|CAPTA3026B4|", key: "GSXN403010|CAPTA3026B4|", want: true},
+		{name: "single wildcards in every model", pattern: "GSXN4*3010|CA*TA3026B4|G*9S800803B", key: "GSXN403010|CAPTA3026B4|GR9S800803B", want: true},
+		{name: "single wildcard needs a character", pattern: "GSXN40301*|CAPTA3026B4|", key: "GSXN40301|CAPTA3026B4|"},
+		{name: "single wildcard is one character", pattern: "GSXN4*010|CAPTA3026B4|", key: "GSXN403010|CAPTA3026B4|"},
+		{name: "single wildcard skips the separator", pattern: "GSXN40301*CAPTA3026B4|", key: "GSXN40301|CAPTA3026B4|"},
+		{name: "multi wildcard matches a run", multiWildcard: '%', pattern: "GSXN%|CAPTA3026B4|", key: "GSXN403010|CAPTA3026B4|", want: true},
+		{name: "multi wildcard matches nothing", multiWildcard: '%', pattern: "GSXN403010%|CAPTA3026B4|", key: "GSXN403010|CAPTA3026B4|", want: true},
+		{name: "multi wildcard ends the key", multiWildcard: '%', pattern: "GSXN403010|CAPTA3026B4|GR9%", key: "GSXN403010|CAPTA3026B4|GR9S800803B", want: true},
+		{name: "trailing multi wildcard matches nothing", multiWildcard: '%', pattern: "GSXN403010|CAPTA3026B4|%", key: "GSXN403010|CAPTA3026B4|", want: true},
+		{name: "multi wildcard in the middle", multiWildcard: '%', pattern: "G%010|CAPTA3026B4|", key: "GSXN403010|CAPTA3026B4|", want: true},
+		{name: "multi wildcard stays in its model", multiWildcard: '%', pattern: "GSXN%3026B4|", key: "GSXN403010|CAPTA3026B4|"},
+		{name: "multi wildcard unset is literal", pattern: "GSXN%|CAPTA3026B4|", key: "GSXN403010|CAPTA3026B4|"},
+		{name: "multi wildcard unset matches itself", pattern: "GSXN%|CAPTA3026B4|", key: "GSXN%|CAPTA3026B4|", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := testAHRIIndex(t, nil, tt.multiWildcard)
+			addTestKey(index, tt.pattern, data_structures.AHRIRecord{AHRINumber: "1001"})
+
+			_, got := index.Lookup("Goodman", systemTypeValue("central ac & furnace"), tt.key)
+			if got != tt.want {
+				t.Errorf("%q matches %q = %v, want %v", tt.pattern, tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAHRIIndexLookup(t *testing.T) {
+	key := ahriKey("GSXN403010", "CAPTA3026B4", "")
+
+	type entry struct {
+		number  string
+		pattern string
+	}
+	tests := []struct {
+		name    string
+		entries []entry
+		want    []string
+		wantLen int
+	}{
+		{name: "no records", want: []string{}},
+		{
+			name:    "every record of a key in input order",
+			entries: []entry{{"1002", key}, {"1001", key}},
+			want:    []string{"1002", "1001"},
+			wantLen: 1,
+		},
+		{
+			name:    "an AHRI number listed twice is returned once",
+			entries: []entry{{"1001", key}, {"1001", key}},
+			want:    []string{"1001"},
+			wantLen: 1,
+		},
+		{
+			name:    "exact records before wildcard records",
+			entries: []entry{{"1001", "GSXN4*3010|CAPTA3026B4|"}, {"1002", key}},
+			want:    []string{"1002", "1001"},
+			wantLen: 2,
+		},
+		{
+			name:    "exact and wildcard record with one AHRI number",
+			entries: []entry{{"1001", key}, {"1001", "GSXN4*3010|CA%|"}},
+			want:    []string{"1001"},
+			wantLen: 2,
+		},
+		{
+			name:    "wildcard record reached along several paths",
+			entries: []entry{{"1001", "G%0%|CAPTA3026B4|"}},
+			want:    []string{"1001"},
+			wantLen: 1,
+		},
+		{
+			name:    "no match",
+			entries: []entry{{"1001", "GSXN406010|CAPTA3026B4|"}},
+			want:    []string{},
+			wantLen: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := testAHRIIndex(t, nil, '%')
+			for _, e := range tt.entries {
+				addTestKey(index, e.pattern, data_structures.AHRIRecord{AHRINumber: e.number})
+			}
+
+			records, certified := index.Lookup("Goodman", systemTypeValue("central ac & furnace"), key)
+			got := []string{}
+			for _, record := range records {
+				got = append(got, record.AHRINumber)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Lookup = %v, want %v", got, tt.want)
+			}
+			if certified != (len(tt.want) > 0) {
+				t.Errorf("certified = %v, want %v", certified, len(tt.want) > 0)
+			}
+			if index.Len() != tt.wantLen {
+				t.Errorf("Len = %d, want %d", index.Len(), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestAHRIIndexForEachKey(t *testing.T) {
+	record := func(number, manufacturer, program, outdoor, indoor, furnace string) data_structures.AHRIRecord {
+		return data_structures.AHRIRecord{
+			AHRINumber:   number,
+			Manufacturer: manufacturer,
+			Program:      program,
+			OutdoorUnit:  data_structures.Equipment{InputModelNumber: outdoor},
+			IndoorUnit:   data_structures.Equipment{InputModelNumber: indoor},
+			Furnace:      data_structures.Equipment{InputModelNumber: furnace},
+		}
+	}
+	index := testAHRIIndex(t, []data_structures.AHRIRecord{
+		record("1001", "", "", "GSXN403010", "CAPTA3026B4", "GR9S800803B"),
+		record("1002", "", "", "GSXN403010", "CAPTA3026B4", "GR9S800803B"), // same key, visited once
+		record("1003", "Goodman", "", "GSXN4*3010", "CA%", ""),
+		record("1004", "", "heat pump & air handler", "GSZB403010", "AMST30BU130", ""),
+	}, '%')
+
+	tests := []struct {
+		name    string
+		sysType string // a system type value
+		want    []string
+	}{
+		{
+			name:    "unqualified and manufacturer keys",
+			sysType: "central_ac_furnace",
+			want:    []string{"/GSXN403010|CAPTA3026B4|GR9S800803B", "goodman/GSXN4*3010|CA%|"},
+		},
+		{
+			name:    "program keys only for their system type",
+			sysType: "air_source_heat_pump_electric_heat",
+			want:    []string{"/GSXN403010|CAPTA3026B4|GR9S800803B", "/GSZB403010|AMST30BU130|", "goodman/GSXN4*3010|CA%|"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			index.forEachKey(tt.sysType, func(manufacturer string, key string) {
+				got = append(got, manufacturer+"/"+key)
+			})
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("keys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAHRIIndexPartitions(t *testing.T) {
+	record := func(number, manufacturer, program string) data_structures.AHRIRecord {
+		return data_structures.AHRIRecord{
+			AHRINumber:   number,
+			Manufacturer: manufacturer,
+			Program:      program,
+			OutdoorUnit:  data_structures.Equipment{InputModelNumber: "GSXN403010"},
+			IndoorUnit:   data_structures.Equipment{InputModelNumber: "CAPTA3026B4"},
+		}
+	}
+	key := ahriKey("GSXN403010", "CAPTA3026B4", "")
+	acFurnace := systemTypeValue("central ac & furnace")
+
+	tests := []struct {
+		name    string
+		records []data_structures.AHRIRecord
+		opts    data_structures.AHRIIndexOptions
+		brand   string
+		sysType string
+		want    []string
+	}{
+		{
+			name:    "manufacturer records first",
+			records: []data_structures.AHRIRecord{record("1001", "", ""), record("1002", "Goodman", "")},
+			brand:   "Goodman",
+			want:    []string{"1002", "1001"},
+		},
+		{
+			name:    "manufacturers compared ignoring case",
+			records: []data_structures.AHRIRecord{record("1001", " GOODMAN ", "")},
+			brand:   "goodman",
+			want:    []string{"1001"},
+		},
+		{
+			name:    "other manufacturers' records are skipped",
+			records: []data_structures.AHRIRecord{record("1001", "Amana", ""), record("1002", "", "")},
+			brand:   "Goodman",
+			want:    []string{"1002"},
+		},
+		{
+			name:    "alias",
+			records: []data_structures.AHRIRecord{record("1001", "Goodman Manufacturing", "")},
+			opts:    data_structures.AHRIIndexOptions{ManufacturerAliases: map[string][]string{"goodman manufacturing": {"Goodman", "Wilson"}}},
+			brand:   "Wilson",
+			want:    []string{"1001"},
+		},
+		{
+			name:    "alias still certifies its own name",
+			records: []data_structures.AHRIRecord{record("1001", "Goodman Manufacturing", "")},
+			opts:    data_structures.AHRIIndexOptions{ManufacturerAliases: map[string][]string{"Goodman Manufacturing": {"Goodman"}}},
+			brand:   "Goodman Manufacturing",
+			want:    []string{"1001"},
+		},
+		{
+			name:    "no alias",
+			records: []data_structures.AHRIRecord{record("1001", "Goodman Manufacturing", "")},
+			brand:   "Goodman",
+			want:    []string{},
+		},
+		{
+			name:    "program named after a system type",
+			records: []data_structures.AHRIRecord{record("1001", "", "Central AC & Furnace"), record("1002", "", "heat pump")},
+			brand:   "Goodman",
+			want:    []string{"1001"},
+		},
+		{
+			name:    "program named after an output value",
+			records: []data_structures.AHRIRecord{record("1001", "", "central_ac_furnace")},
+			brand:   "Goodman",
+			want:    []string{"1001"},
+		},
+		{
+			name:    "mapped program",
+			records: []data_structures.AHRIRecord{record("1001", "", "RCU-A-CB"), record("1002", "", "heat pump & furnace")},
+			opts:    data_structures.AHRIIndexOptions{Programs: map[string][]string{"RCU-A-CB": {"central ac & furnace", "central ac & air handler"}}},
+			brand:   "Goodman",
+			want:    []string{"1001"},
+		},
+		{
+			// Logged, then treated as if it had no program
+			name:    "unknown program",
+			records: []data_structures.AHRIRecord{record("1001", "", "HRCU-A-CB")},
+			brand:   "Goodman",
+			sysType: systemTypeValue("heat pump"),
+			want:    []string{"1001"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.MultiWildcard = '%'
+			index, err := BuildAHRIIndex(tt.records, tt.opts)
+			if err != nil {
+				t.Fatalf("BuildAHRIIndex: %v", err)
+			}
+			if tt.sysType == "" {
+				tt.sysType = acFurnace
+			}
+
+			records, _ := index.Lookup(tt.brand, tt.sysType, key)
+			got := []string{}
+			for _, record := range records {
+				got = append(got, record.AHRINumber)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Lookup = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	_, err := BuildAHRIIndex(nil, data_structures.AHRIIndexOptions{Programs: map[string][]string{"RCU-A-CB": {"boiler"}}})
+	if err == nil {
+		t.Error("program mapped to an unknown system type was accepted")
+	}
+}
+
+func TestUnpartitionedBrands(t *testing.T) {
+	brands := []string{"Amana", "Goodman", "Wilson", "Trane"}
+	record := func(number, manufacturer string) data_structures.AHRIRecord {
+		return data_structures.AHRIRecord{AHRINumber: number, Manufacturer: manufacturer}
+	}
+
+	tests := []struct {
+		name    string
+		records []data_structures.AHRIRecord
+		aliases map[string][]string
+		want    []string
+	}{
+		{name: "no manufacturer column", records: []data_structures.AHRIRecord{record("1001", "")}, want: []string{}},
+		{
+			name:    "by name",
+			records: []data_structures.AHRIRecord{record("1001", "GOODMAN"), record("1002", "Amana"), record("1003", "")},
+			want:    []string{"Wilson", "Trane"},
+		},
+		{
+			name:    "by alias",
+			records: []data_structures.AHRIRecord{record("1001", "Goodman Manufacturing")},
+			aliases: map[string][]string{"Goodman Manufacturing": {"Goodman", "Wilson"}},
+			want:    []string{"Amana", "Trane"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, err := BuildAHRIIndex(tt.records, data_structures.AHRIIndexOptions{ManufacturerAliases: tt.aliases})
+			if err != nil {
+				t.Fatalf("BuildAHRIIndex: %v", err)
+			}
+			if got := index.UnpartitionedBrands(brands); !slices.Equal(got, tt.want) {
+				t.Errorf("UnpartitionedBrands = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
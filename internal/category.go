@@ -0,0 +1,199 @@
+package internal
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// builtinCategories are the built in categories, in the order combinations are
+// generated for them; other categories follow in alphabetical order
+var builtinCategories = []string{
+	data_structures.CategoryStandard,
+	data_structures.CategoryCommunicating,
+	data_structures.CategorySingleStage,
+	data_structures.CategoryTwoStage,
+	data_structures.CategoryVariableSpeed,
+	data_structures.CategoryInverter,
+}
+
+/*
+DefaultCategoryRules returns the rules used when no category rules file is supplied.
+They pick out the communicating lines by model series; everything else is standard:
+  - Air handlers: AHVE
+  - Evaporator coils: CAPEA
+  - AC condensers: AXV, GXV
+  - Heat pumps: ASZV9, AZV6, GSZV9, GZV6
+*/
+func DefaultCategoryRules() []data_structures.CategoryRule {
+	communicating := data_structures.CategoryCommunicating
+	return []data_structures.CategoryRule{
+		{Type: "handler", Contains: "ahve", Category: communicating},
+		{Type: "coil", Contains: "capea", Category: communicating},
+		{Type: "ac", Contains: "axv", Category: communicating},
+		{Type: "ac", Contains: "gxv", Category: communicating},
+		{Type: "hp", Contains: "aszv9", Category: communicating},
+		{Type: "hp", Contains: "azv6", Category: communicating},
+		{Type: "hp", Contains: "gszv9", Category: communicating},
+		{Type: "hp", Contains: "gzv6", Category: communicating},
+	}
+}
+
+/*
+LoadCategoryRules reads category rules from a csv file with the columns "Type",
+"Contains" and "Category". Type is the name of an equipment role (e.g. "coil", "hp",
+"packaged ac"; see builtinEquipmentRoles); Contains is part of the normalized model
+number, or blank for every model of the role. Category may be any name.
+*/
+func LoadCategoryRules(filename string) ([]data_structures.CategoryRule, error) {
+	rows, err := readRuleCSV(filename, []string{"Type", "Contains", "Category"})
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []data_structures.CategoryRule{}
+	for i, row := range rows {
+		rule := data_structures.CategoryRule{
+			Type:     strings.ToLower(row["type"]),
+			Contains: strings.ToLower(row["contains"]),
+			Category: strings.ToLower(row["category"]),
+		}
+		if rule.Type == "" || rule.Category == "" {
+			return nil, fmt.Errorf("line %d: category rules need a type and a category", i+2)
+		}
+		if _, exists := findEquipmentRole(rule.Type); !exists {
+			return nil, fmt.Errorf("line %d: unknown equipment role %q", i+2, rule.Type)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+/*
+LoadCategoryPairings reads the category pairing matrix from a csv file with the
+columns "Outdoor Category" and "Indoor Category", one row per pair of categories
+that may be combined (see MatchOptions.CategoryPairings).
+*/
+func LoadCategoryPairings(filename string) ([]data_structures.CategoryPairing, error) {
+	rows, err := readRuleCSV(filename, []string{"Outdoor Category", "Indoor Category"})
+	if err != nil {
+		return nil, err
+	}
+
+	pairings := []data_structures.CategoryPairing{}
+	for i, row := range rows {
+		pairing := data_structures.CategoryPairing{
+			Outdoor: strings.ToLower(row["outdoor category"]),
+			Indoor:  strings.ToLower(row["indoor category"]),
+		}
+		if pairing.Outdoor == "" || pairing.Indoor == "" {
+			return nil, fmt.Errorf("line %d: category pairings need an outdoor and an indoor category", i+2)
+		}
+		pairings = append(pairings, pairing)
+	}
+
+	return pairings, nil
+}
+
+// readRuleCSV reads a small configuration csv into one map of lower case column
+// name -> trimmed value per row
+func readRuleCSV(filename string, columns []string) ([]map[string]string, error) {
+	headers, err := GetCSVHeader(filename, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error with opening %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+
+	if _, err := r.Read(); err != nil {
+		return nil, fmt.Errorf("error reading header: %w", err)
+	}
+
+	rows := []map[string]string{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV: %w", err)
+		}
+
+		row := make(map[string]string, len(columns))
+		for _, column := range columns {
+			name := strings.ToLower(column)
+			if idx := headers[name]; idx < len(record) {
+				row[name] = strings.TrimSpace(record[idx])
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// CategorizeEquipment sets the equipment category from the first rule for the
+// equipment's role (see equipmentRole) whose model matches. Equipment that matches
+// no rule, or whose type plays no role, is standard.
+func CategorizeEquipment(equipment data_structures.Equipment, rules []data_structures.CategoryRule) data_structures.Equipment {
+	modelLower := strings.ToLower(equipment.NormalizedModelNumber)
+	role, _ := equipmentRole(equipment.Type)
+
+	for _, rule := range rules {
+		if role != "" && rule.Type == role && strings.Contains(modelLower, rule.Contains) {
+			equipment.Category = rule.Category
+			return equipment
+		}
+	}
+
+	equipment.Category = data_structures.CategoryStandard
+	return equipment
+}
+
+// EquipmentCategories returns the categories in the equipment list in the order
+// combinations are generated for them: built in categories first, then the rest
+// alphabetically.
+func EquipmentCategories(list []data_structures.Equipment) []string {
+	present := make(map[string]bool)
+	for _, item := range list {
+		present[item.Category] = true
+	}
+
+	categories := []string{}
+	for _, category := range builtinCategories {
+		if present[category] {
+			categories = append(categories, category)
+			delete(present, category)
+		}
+	}
+
+	others := make([]string, 0, len(present))
+	for category := range present {
+		others = append(others, category)
+	}
+	sort.Strings(others)
+
+	return append(categories, others...)
+}
+
+// categoryPairing returns a check for whether an outdoor unit of one category may
+// be paired with an indoor unit of another
+func categoryPairing(pairings []data_structures.CategoryPairing) func(outdoor string, indoor string) bool {
+	return func(outdoor string, indoor string) bool {
+		return outdoor == indoor || slices.Contains(pairings, data_structures.CategoryPairing{Outdoor: outdoor, Indoor: indoor})
+	}
+}
@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+func TestCategorizeEquipment(t *testing.T) {
+	tests := []struct {
+		name      string
+		equipType string
+		model     string
+		want      string
+	}{
+		{name: "communicating air handler", equipType: "air handler", model: "AHVE30BU1300", want: data_structures.CategoryCommunicating},
+		{name: "communicating coil", equipType: "evaporator coil", model: "CAPEA3026B4", want: data_structures.CategoryCommunicating},
+		{name: "communicating ac", equipType: "outdoor unit (ac)", model: "GXV603010", want: data_structures.CategoryCommunicating},
+		{name: "communicating heat pump", equipType: "outdoor unit (hp)", model: "GZV603010", want: data_structures.CategoryCommunicating},
+		{name: "standard coil", equipType: "evaporator coil", model: "CAPTA3026B4", want: data_structures.CategoryStandard},
+		{name: "series of another role", equipType: "evaporator coil", model: "AHVE30BU1300", want: data_structures.CategoryStandard},
+		{name: "packaged ac is not an ac condenser", equipType: "Packaged Unit (AC)", model: "GXV603010", want: data_structures.CategoryStandard},
+		{name: "furnace is not an ac condenser", equipType: "furnace", model: "GXV603010", want: data_structures.CategoryStandard},
+		{name: "unknown type", equipType: "boiler", model: "GXV603010", want: data_structures.CategoryStandard},
+	}
+
+	rules := DefaultCategoryRules()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			equipment := data_structures.Equipment{Type: tt.equipType, NormalizedModelNumber: tt.model, Category: "stale"}
+			if got := CategorizeEquipment(equipment, rules).Category; got != tt.want {
+				t.Errorf("category = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// A rule with no Contains takes every model of its role
+	rules = []data_structures.CategoryRule{{Type: "packaged ac", Category: "rooftop"}}
+	equipment := data_structures.Equipment{Type: "Packaged Unit (AC)", NormalizedModelNumber: "GPC1336H41"}
+	if got := CategorizeEquipment(equipment, rules).Category; got != "rooftop" {
+		t.Errorf("category = %q, want rooftop", got)
+	}
+}
+
+func TestLoadCategoryRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []data_structures.CategoryRule
+		wantErr string
+	}{
+		{
+			name:    "valid rules",
+			content: "Type,Contains,Category\nHP,DZ20,Variable-Speed\ncoil,,two-stage\n",
+			want: []data_structures.CategoryRule{
+				{Type: "hp", Contains: "dz20", Category: data_structures.CategoryVariableSpeed},
+				{Type: "coil", Contains: "", Category: data_structures.CategoryTwoStage},
+			},
+		},
+		{name: "empty category", content: "Type,Contains,Category\ncoil,capea,\n", wantErr: "line 2: category rules need a type and a category"},
+		{name: "empty type", content: "Type,Contains,Category\ncoil,capea,communicating\n,axv,communicating\n", wantErr: "line 3: category rules need a type and a category"},
+		{name: "unknown role", content: "Type,Contains,Category\nevaporator coil,capea,communicating\n", wantErr: `line 2: unknown equipment role "evaporator coil"`},
+		{name: "missing column", content: "Type,Category\ncoil,communicating\n", wantErr: "Contains"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "categories.csv")
+			if err := os.WriteFile(filename, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := LoadCategoryRules(filename)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadCategoryRules error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadCategoryRules: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rules = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadCategoryPairings(t *testing.T) {
+	filename := writeTestCSV(t, "Outdoor Category,Indoor Category\nVariable-Speed,Two-Stage\ninverter,standard\n")
+	got, err := LoadCategoryPairings(filename)
+	if err != nil {
+		t.Fatalf("LoadCategoryPairings: %v", err)
+	}
+	want := []data_structures.CategoryPairing{
+		{Outdoor: data_structures.CategoryVariableSpeed, Indoor: data_structures.CategoryTwoStage},
+		{Outdoor: data_structures.CategoryInverter, Indoor: data_structures.CategoryStandard},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pairings = %+v, want %+v", got, want)
+	}
+
+	filename = writeTestCSV(t, "Outdoor Category,Indoor Category\nvariable-speed,\n")
+	if _, err := LoadCategoryPairings(filename); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("LoadCategoryPairings error = %v, want one for line 2", err)
+	}
+}
+
+func TestEquipmentCategories(t *testing.T) {
+	list := []data_structures.Equipment{
+		{Category: "rooftop"},
+		{Category: data_structures.CategoryCommunicating},
+		{Category: data_structures.CategoryInverter},
+		{Category: "dual-fuel"},
+		{Category: data_structures.CategoryStandard},
+		{Category: data_structures.CategoryCommunicating},
+	}
+
+	got := EquipmentCategories(list)
+	want := []string{data_structures.CategoryStandard, data_structures.CategoryCommunicating, data_structures.CategoryInverter, "dual-fuel", "rooftop"}
+	if !slices.Equal(got, want) {
+		t.Errorf("categories = %v, want %v", got, want)
+	}
+}
+
+func TestCategoryPairings(t *testing.T) {
+	list := engineTestEquipment()
+	ahriIndex := testAHRIIndex(t, engineTestRecords(), '%')
+	pairings := []data_structures.CategoryPairing{
+		{Outdoor: data_structures.CategoryCommunicating, Indoor: data_structures.CategoryStandard},
+	}
+
+	tests := []struct {
+		name     string
+		pairings []data_structures.CategoryPairing
+		want     bool // whether the communicating condenser's standard coil match (1009) is found
+	}{
+		{name: "same category only", want: false},
+		{name: "communicating outdoor with standard indoor", pairings: pairings, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cartesian, err := GenerateFullSystemEquipmentConfig(context.Background(), list, "central ac & furnace", tt.pairings)
+			if err != nil {
+				t.Fatalf("cartesian engine: %v", err)
+			}
+			certified, err := GenerateCertifiedSystemEquipmentConfig(context.Background(), list, "central ac & furnace", ahriIndex, nil, tt.pairings)
+			if err != nil {
+				t.Fatalf("certification engine: %v", err)
+			}
+
+			opts := data_structures.MatchOptions{CategoryPairings: tt.pairings}
+			want, err := FindCertifiedMatches(context.Background(), slices.Values(cartesian), ahriIndex, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := FindCertifiedMatches(context.Background(), slices.Values(certified), ahriIndex, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("certification engine matches differ\ngot:  %+v\nwant: %+v", got, want)
+			}
+
+			found := slices.ContainsFunc(want, func(match data_structures.OutputCSV) bool { return match.AHRINumber == "1009" })
+			if found != tt.want {
+				t.Errorf("record 1009 matched = %v, want %v", found, tt.want)
+			}
+		})
+	}
+}
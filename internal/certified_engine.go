@@ -0,0 +1,328 @@
+package internal
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// Values for the match engine setting in main.go
+const (
+	EngineCartesian     = "cartesian"
+	EngineCertification = "certification"
+)
+
+/*
+StockIndex finds stocked equipment by role and AHRI model key (see modelKey), so the
+certification engine can go from an AHRI record to the equipment it certifies. It is
+built once per run from the whole equipment list; each job then picks out its own
+equipment. Models are also kept in a trie per role, so an AHRI model with wildcards
+only visits the stocked models it could match.
+*/
+type StockIndex struct {
+	byModel map[string]map[string][]data_structures.Equipment // role -> model key -> equipment
+	models  map[string]*stockTrieNode                         // role -> model keys
+}
+
+// stockTrieNode is a node of a trie of stocked model keys
+type stockTrieNode struct {
+	children map[byte]*stockTrieNode
+	model    string // the model key ending at this node, if any
+}
+
+func newStockTrieNode() *stockTrieNode {
+	return &stockTrieNode{children: make(map[byte]*stockTrieNode)}
+}
+
+// BuildStockIndex indexes the equipment list under each model key it can be certified
+// by: its own and those of the models it replaced (see predecessors).
+func BuildStockIndex(list []data_structures.Equipment, ahriIndex *AHRIIndex) (*StockIndex, error) {
+	stock := &StockIndex{
+		byModel: make(map[string]map[string][]data_structures.Equipment),
+		models:  make(map[string]*stockTrieNode),
+	}
+
+	for _, item := range list {
+		role, err := equipmentRole(item.Type)
+		if err != nil {
+			return nil, err
+		}
+		stock.add(role, ahriIndex.modelKey(roleComponent(role), item), item)
+
+		// Equipment can also be certified under the models it replaced
+		for _, predecessor := range ahriIndex.predecessors(item) {
+			stock.add(role, ahriIndex.modelKey(roleComponent(role), predecessor), item)
+		}
+	}
+
+	return stock, nil
+}
+
+func (stock *StockIndex) add(role string, model string, item data_structures.Equipment) {
+	if stock.byModel[role] == nil {
+		stock.byModel[role] = make(map[string][]data_structures.Equipment)
+		stock.models[role] = newStockTrieNode()
+	}
+	if slices.Contains(stock.byModel[role][model], item) {
+		return
+	}
+	stock.byModel[role][model] = append(stock.byModel[role][model], item)
+
+	node := stock.models[role]
+	for i := 0; i < len(model); i++ {
+		child, exists := node.children[model[i]]
+		if !exists {
+			child = newStockTrieNode()
+			node.children[model[i]] = child
+		}
+		node = child
+	}
+	node.model = model
+}
+
+// find returns the stocked equipment in role matching an AHRI model, wildcards included
+func (stock *StockIndex) find(role string, pattern string, ahriIndex *AHRIIndex) []data_structures.Equipment {
+	if !ahriIndex.hasWildcard(pattern) {
+		return stock.byModel[role][pattern]
+	}
+
+	root, exists := stock.models[role]
+	if !exists {
+		return nil
+	}
+
+	found := []data_structures.Equipment{}
+	type visit struct {
+		node *stockTrieNode
+		pos  int
+	}
+	visited := make(map[visit]bool)
+
+	var walk func(node *stockTrieNode, pos int)
+	walk = func(node *stockTrieNode, pos int) {
+		if visited[visit{node, pos}] {
+			return
+		}
+		visited[visit{node, pos}] = true
+
+		if pos == len(pattern) {
+			// Equipment indexed under its own model and a predecessor's is found once
+			for _, item := range stock.byModel[role][node.model] {
+				if !slices.Contains(found, item) {
+					found = append(found, item)
+				}
+			}
+			return
+		}
+
+		switch c := pattern[pos]; {
+		case c == WildcardChar:
+			for _, child := range node.children {
+				walk(child, pos+1)
+			}
+		case ahriIndex.multiWildcard != 0 && c == ahriIndex.multiWildcard:
+			// Match nothing, or one more character and stay on the wildcard
+			walk(node, pos+1)
+			for _, child := range node.children {
+				walk(child, pos)
+			}
+		default:
+			if child, exists := node.children[c]; exists {
+				walk(child, pos+1)
+			}
+		}
+	}
+	walk(root, 0)
+
+	return found
+}
+
+// jobStock finds a job's stocked equipment, by position in the job's equipment list
+type jobStock struct {
+	stock     *StockIndex
+	ahriIndex *AHRIIndex
+	positions map[data_structures.Equipment][]int
+}
+
+func newJobStock(list []data_structures.Equipment, stock *StockIndex, ahriIndex *AHRIIndex) *jobStock {
+	positions := make(map[data_structures.Equipment][]int)
+	for i, item := range list {
+		positions[item] = append(positions[item], i)
+	}
+	return &jobStock{stock: stock, ahriIndex: ahriIndex, positions: positions}
+}
+
+// find returns the positions of the job's equipment in role matching an AHRI model
+func (job *jobStock) find(role string, pattern string) []int {
+	positions := []int{}
+	for _, item := range job.stock.find(role, pattern, job.ahriIndex) {
+		positions = append(positions, job.positions[item]...)
+	}
+	return positions
+}
+
+/*
+GenerateCertifiedSystemEquipmentConfig is the certification driven alternative to
+GenerateFullSystemEquipmentConfig. Instead of building every outdoor x indoor x furnace
+product and looking each one up, it walks the AHRI index and keeps only the
+combinations whose models are all stocked, so the work scales with the number of
+certifications rather than the size of the catalog.
+
+It applies the same pairing rules and returns the combinations in the same order as
+the Cartesian generator, minus those that could never be certified, so
+FindCertifiedMatches produces identical results from either.
+System types that aren't always AHRI certified (furnace, central ac) fall back to
+the Cartesian generator. stock is the run's StockIndex, or nil to index the list
+on the spot. Equipment list provided must all be from the same brand (or brand group).
+*/
+func GenerateCertifiedSystemEquipmentConfig(
+	ctx context.Context,
+	list []data_structures.Equipment,
+	sysType string,
+	ahriIndex *AHRIIndex,
+	stock *StockIndex,
+	pairings []data_structures.CategoryPairing,
+) ([]data_structures.ComponentKey, error) {
+	system, known := findSystemType(sysType)
+	if !known || !system.ahriDriven() {
+		return GenerateFullSystemEquipmentConfig(ctx, list, sysType, pairings)
+	}
+
+	if stock == nil {
+		var err error
+		if stock, err = BuildStockIndex(list, ahriIndex); err != nil {
+			return nil, err
+		}
+	}
+	inStock := newJobStock(list, stock, ahriIndex)
+
+	if len(system.Heads) > 0 {
+		return generateCertifiedHeadCombos(ctx, list, system, ahriIndex, inStock, pairings)
+	}
+
+	// Candidate combinations as list positions; indoor and furnace are -1 when the system has none
+	type candidate struct {
+		outdoor, indoor, furnace int
+	}
+	seen := make(map[candidate]bool)
+	candidates := []candidate{}
+
+	categories := EquipmentCategories(list)
+	categoryIdx := func(pos int) int {
+		return slices.Index(categories, list[pos].Category)
+	}
+	pairs := categoryPairing(pairings)
+
+	var ctxErr error
+	keysChecked := 0
+
+	ahriIndex.forEachKey(system.Value, func(manufacturer string, key string) {
+		if ctxErr != nil {
+			return
+		}
+		if keysChecked%cancelCheckInterval == 0 {
+			if ctxErr = ctx.Err(); ctxErr != nil {
+				return
+			}
+		}
+		keysChecked++
+
+		models := strings.Split(key, string(keySeparator))
+		if len(models) != 3 {
+			return
+		}
+
+		outdoors := inStock.find(system.Outdoor, models[0])
+		if len(outdoors) == 0 {
+			return
+		}
+
+		// Packaged systems have no indoor unit
+		indoors := []int{-1}
+		if system.Indoor != "" {
+			indoors = inStock.find(system.Indoor, models[1])
+		} else if !ahriIndex.matchModel(models[1], "") {
+			indoors = nil
+		}
+		if len(indoors) == 0 {
+			return
+		}
+
+		furnaces := []int{}
+		if system.Furnace {
+			furnaces = inStock.find("furnace", models[2])
+		} else if ahriIndex.matchModel(models[2], "") {
+			furnaces = []int{-1}
+		}
+
+		for _, o := range outdoors {
+			// Manufacturer specific records only certify that brand's combinations
+			if !ahriIndex.certifiesBrand(manufacturer, qualifierValue(list[o].Brand)) {
+				continue
+			}
+			for _, i := range indoors {
+				if i != -1 && !pairs(list[o].Category, list[i].Category) {
+					continue
+				}
+				// Furnaces are shared across categories
+				for _, f := range furnaces {
+					c := candidate{outdoor: o, indoor: i, furnace: f}
+					if !seen[c] {
+						seen[c] = true
+						candidates = append(candidates, c)
+					}
+				}
+			}
+		}
+	})
+
+	if ctxErr != nil {
+		return nil, ctxErr
+	}
+
+	// Match the Cartesian generator's order: category, outdoor, furnace, indoor.
+	// Furnaces and indoor units are pooled in category order, then list order.
+	componentOrder := func(pos int) [2]int {
+		if pos == -1 {
+			return [2]int{-1, -1}
+		}
+		return [2]int{categoryIdx(pos), pos}
+	}
+	before := func(x, y [2]int) bool {
+		return x[0] < y[0] || (x[0] == y[0] && x[1] < y[1])
+	}
+	sort.Slice(candidates, func(a, b int) bool {
+		ca, cb := candidates[a], candidates[b]
+		if x, y := categoryIdx(ca.outdoor), categoryIdx(cb.outdoor); x != y {
+			return x < y
+		}
+		if ca.outdoor != cb.outdoor {
+			return ca.outdoor < cb.outdoor
+		}
+		if x, y := componentOrder(ca.furnace), componentOrder(cb.furnace); x != y {
+			return before(x, y)
+		}
+		return before(componentOrder(ca.indoor), componentOrder(cb.indoor))
+	})
+
+	equipConfigs := make([]data_structures.ComponentKey, 0, len(candidates))
+	for _, c := range candidates {
+		combo := data_structures.ComponentKey{
+			Brand:       list[c.outdoor].Brand,
+			OutdoorUnit: list[c.outdoor],
+			SystemType:  system.Value,
+		}
+		if c.indoor != -1 {
+			combo.IndoorUnit = list[c.indoor]
+		}
+		if c.furnace != -1 {
+			combo.Furnace = list[c.furnace]
+		}
+		equipConfigs = append(equipConfigs, combo)
+	}
+
+	return equipConfigs, nil
+}
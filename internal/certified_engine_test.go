@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// engineTestEquipment is a small catalog covering every equipment role
+func engineTestEquipment() []data_structures.Equipment {
+	stocked := []struct{ brand, equipType, model string }{
+		{"Goodman", "furnace", "GR9S800803BN"},
+		{"Goodman", "furnace", "GD9S800803BN"},
+		{"Goodman", "furnace", "GM9S800803BN"},
+		{"Goodman", "outdoor unit (ac)", "GSXN403010"},
+		{"Goodman", "outdoor unit (ac)", "GSXN406010"},
+		{"Goodman", "outdoor unit (ac)", "GXV603010"},
+		{"Goodman", "outdoor unit (hp)", "GSZB403010"},
+		{"Goodman", "outdoor unit (hp)", "GZV603010"},
+		{"Goodman", "evaporator coil", "CAPTA3026B4"},
+		{"Goodman", "evaporator coil", "CHPTA3026B4"},
+		{"Goodman", "evaporator coil", "CAPEA3026B4"},
+		{"Goodman", "air handler", "AMST30BU1300"},
+		{"Goodman", "air handler", "AHVE30BU1300"},
+	}
+
+	orientations := DefaultOrientationRules()
+	list := make([]data_structures.Equipment, 0, len(stocked))
+	for _, s := range stocked {
+		item := NormalizeString(data_structures.Equipment{InputModelNumber: s.model, Brand: s.brand, Type: s.equipType})
+		item = CategorizeEquipment(item, DefaultCategoryRules())
+		list = append(list, AssignOrientation(item, orientations))
+	}
+	return list
+}
+
+// engineTestRecords certifies some of engineTestEquipment, with exact, single and
+// multi character wildcard models ('%' is the multi character wildcard)
+func engineTestRecords() []data_structures.AHRIRecord {
+	record := func(number, outdoor, indoor, furnace string) data_structures.AHRIRecord {
+		return data_structures.AHRIRecord{
+			AHRINumber:  number,
+			OutdoorUnit: data_structures.Equipment{InputModelNumber: outdoor},
+			IndoorUnit:  data_structures.Equipment{InputModelNumber: indoor},
+			Furnace:     data_structures.Equipment{InputModelNumber: furnace},
+		}
+	}
+
+	return []data_structures.AHRIRecord{
+		record("1001", "GSXN403010", "CAPTA3026B4", "GR9S800803BN"),
+		record("1002", "GSXN403010", "CA*TA3026B4", "G*9S800803BN"),
+		record("1003", "GSXN406010", "AMST30BU1300", ""),
+		record("1004", "GSZB403010", "CAPTA3026B4", "G%803BN"),
+		record("1005", "GSZB403010", "AMST30BU1300", ""),
+		record("1006", "GSZB403010", "C%TA3026B4", ""),
+		record("1007", "GZV603010", "CAPEA3026B4", "GM9S800803BN"),
+		record("1008", "GXV603010", "AHVE30BU1300", ""),
+		record("1009", "GXV603010", "CAPTA3026B4", "GR9S800803BN"), // categories don't pair
+	}
+}
+
+// engineTestSystemTypes are the system types main.go generates
+var engineTestSystemTypes = []string{
+	"furnace",
+	"central ac",
+	"central ac & air handler",
+	"central ac & furnace",
+	"heat pump & air handler",
+	"heat pump & furnace",
+	"heat pump",
+}
+
+func TestEnginesProduceIdenticalMatches(t *testing.T) {
+	tests := []struct {
+		name          string
+		multiWildcard byte
+		manufacturer  string // of every record
+		aliases       map[string][]string
+		wantNone      bool // no combination is AHRI certified
+	}{
+		{name: "single character wildcards"},
+		{name: "multi character wildcards", multiWildcard: '%'},
+		{name: "manufacturer", multiWildcard: '%', manufacturer: "GOODMAN"},
+		{name: "manufacturer alias", multiWildcard: '%', manufacturer: "Goodman Manufacturing", aliases: map[string][]string{"Goodman Manufacturing": {"Goodman"}}},
+		{name: "other manufacturer", multiWildcard: '%', manufacturer: "Amana", wantNone: true},
+	}
+
+	list := engineTestEquipment()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := engineTestRecords()
+			for i := range records {
+				records[i].Manufacturer = tt.manufacturer
+			}
+			ahriIndex, err := BuildAHRIIndex(records, data_structures.AHRIIndexOptions{MultiWildcard: tt.multiWildcard, ManufacturerAliases: tt.aliases})
+			if err != nil {
+				t.Fatalf("BuildAHRIIndex: %v", err)
+			}
+			stock, err := BuildStockIndex(list, ahriIndex)
+			if err != nil {
+				t.Fatalf("BuildStockIndex: %v", err)
+			}
+
+			certifiedTotal := 0
+			for _, sysType := range engineTestSystemTypes {
+				cartesian, err := GenerateFullSystemEquipmentConfig(context.Background(), list, sysType, nil)
+				if err != nil {
+					t.Fatalf("%s: cartesian engine: %v", sysType, err)
+				}
+				certified, err := GenerateCertifiedSystemEquipmentConfig(context.Background(), list, sysType, ahriIndex, stock, nil)
+				if err != nil {
+					t.Fatalf("%s: certification engine: %v", sysType, err)
+				}
+
+				want, err := FindCertifiedMatches(context.Background(), slices.Values(cartesian), ahriIndex, data_structures.MatchOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := FindCertifiedMatches(context.Background(), slices.Values(certified), ahriIndex, data_structures.MatchOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%s: certification engine matches differ\ngot:  %+v\nwant: %+v", sysType, got, want)
+				}
+				for _, match := range want {
+					if match.Category == "" {
+						t.Errorf("%s: match %+v has no category", sysType, match)
+					}
+					if match.AHRINumber != "" {
+						certifiedTotal++
+					}
+				}
+			}
+
+			if tt.wantNone && certifiedTotal != 0 {
+				t.Errorf("%d systems certified by another manufacturer's records", certifiedTotal)
+			}
+			if !tt.wantNone && certifiedTotal == 0 {
+				t.Fatalf("no system was certified, so the engines weren't compared")
+			}
+		})
+	}
+}
+
+func TestCertificationEngineSkipsUncertifiable(t *testing.T) {
+	list := engineTestEquipment()
+	ahriIndex := testAHRIIndex(t, engineTestRecords(), '%')
+
+	cartesian, err := GenerateFullSystemEquipmentConfig(context.Background(), list, "heat pump & furnace", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A nil stock index is built from the list
+	certified, err := GenerateCertifiedSystemEquipmentConfig(context.Background(), list, "heat pump & furnace", ahriIndex, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certified) == 0 || len(certified) >= len(cartesian) {
+		t.Errorf("certification engine generated %d of %d combinations, want some but fewer", len(certified), len(cartesian))
+	}
+}
+
+func TestStockIndexFind(t *testing.T) {
+	list := []data_structures.Equipment{}
+	for _, model := range []string{"GSXN403010", "GSXN406010", "GSXN4030", "GXV603010"} {
+		list = append(list, NormalizeString(data_structures.Equipment{InputModelNumber: model, Brand: "Goodman", Type: "outdoor unit (ac)"}))
+	}
+	list = append(list, NormalizeString(data_structures.Equipment{InputModelNumber: "GSZB403010", Brand: "Goodman", Type: "outdoor unit (hp)"}))
+	supersessions := []data_structures.Supersession{{OldModel: "GSXM403010", NewModel: "GSXN403010"}}
+
+	tests := []struct {
+		name          string
+		multiWildcard byte
+		pattern       string
+		want          []string
+	}{
+		{name: "exact model", pattern: "GSXN406010", want: []string{"GSXN406010"}},
+		{name: "not stocked", pattern: "GSXN409010", want: []string{}},
+		{name: "single character wildcard", pattern: "GSXN40*010", want: []string{"GSXN403010", "GSXN406010"}},
+		{name: "wildcard is one character", pattern: "GSXN4030*", want: []string{}},
+		{name: "multi character wildcard", multiWildcard: '%', pattern: "GSXN%", want: []string{"GSXN4030", "GSXN403010", "GSXN406010"}},
+		{name: "multi character wildcard matches nothing", multiWildcard: '%', pattern: "GSXN4030%", want: []string{"GSXN4030", "GSXN403010"}},
+		{name: "multi character wildcard unset", pattern: "GSXN%", want: []string{}},
+		{name: "other roles are not searched", pattern: "GSZB40*010", want: []string{}},
+		{name: "predecessor model", pattern: "GSXM403010", want: []string{"GSXN403010"}},
+		{name: "own and predecessor model found once", pattern: "GSX*403010", want: []string{"GSXN403010"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ahriIndex, err := BuildAHRIIndex(nil, data_structures.AHRIIndexOptions{MultiWildcard: tt.multiWildcard, Supersessions: supersessions})
+			if err != nil {
+				t.Fatalf("BuildAHRIIndex: %v", err)
+			}
+			stock, err := BuildStockIndex(list, ahriIndex)
+			if err != nil {
+				t.Fatalf("BuildStockIndex: %v", err)
+			}
+
+			got := []string{}
+			for _, item := range stock.find("ac", tt.pattern, ahriIndex) {
+				got = append(got, item.InputModelNumber)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("find(%q) = %v, want %v", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package internal
 
 import (
+	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
@@ -73,43 +75,50 @@ func BrandIdentify(list []data_structures.Equipment) map[string]bool {
 	return brandMap
 }
 
-func CategorizeEquipment(equipment data_structures.Equipment) data_structures.Equipment {
-	modelLower := strings.ToLower(equipment.NormalizedModelNumber)
-	typeLower := strings.ToLower(equipment.Type)
-
-	// Check for air handlers
-	if strings.Contains(typeLower, "handler") || strings.Contains(typeLower, "air handler") {
-		if strings.Contains(modelLower, "ahve") {
-			equipment.Category = data_structures.CategoryCommunicating
-			return equipment
-		}
+// SortedBrands returns the brands from BrandIdentify in alphabetical order.
+func SortedBrands(brandMap map[string]bool) []string {
+	brands := make([]string, 0, len(brandMap))
+	for brand := range brandMap {
+		brands = append(brands, brand)
 	}
+	sort.Strings(brands)
+	return brands
+}
 
-	// Check for evaporator coils
-	if strings.Contains(typeLower, "coil") {
-		if strings.Contains(modelLower, "capea") {
-			equipment.Category = data_structures.CategoryCommunicating
-			return equipment
+/*
+GroupBrands splits the brands into the groups combinations are generated within.
+Brands listed together in groups (sister brands sharing a factory, etc.) form one
+group; every other brand is a group of its own. Groups are returned in the order
+their first brand appears in brands, each listing only the brands present there.
+A brand may belong to only one group.
+*/
+func GroupBrands(brands []string, groups [][]string) ([][]string, error) {
+	groupOf := make(map[string]int)
+	for i, group := range groups {
+		for _, brand := range group {
+			if other, exists := groupOf[brand]; exists && other != i {
+				return nil, fmt.Errorf("brand %s is in more than one brand group", brand)
+			}
+			groupOf[brand] = i
 		}
 	}
 
-	// Check for AC outdoor units (both "condenser(ac)" and "outdoor unit (ac)")
-	if strings.Contains(typeLower, "ac") {
-		if strings.Contains(modelLower, "axv") || strings.Contains(modelLower, "gxv") {
-			equipment.Category = data_structures.CategoryCommunicating
-			return equipment
-		}
-	}
+	grouped := [][]string{}
+	position := make(map[int]int) // configured group -> index in grouped
 
-	// Check for heat pump outdoor units (both "condenser(hp)" and "outdoor unit (hp)")
-	if strings.Contains(typeLower, "hp") {
-		if strings.Contains(modelLower, "aszv9") || strings.Contains(modelLower, "azv6") || strings.Contains(modelLower, "gszv9") || strings.Contains(modelLower, "gzv6") {
-			equipment.Category = data_structures.CategoryCommunicating
-			return equipment
+	for _, brand := range brands {
+		i, inGroup := groupOf[brand]
+		if !inGroup {
+			grouped = append(grouped, []string{brand})
+			continue
+		}
+		if pos, exists := position[i]; exists {
+			grouped[pos] = append(grouped[pos], brand)
+			continue
 		}
+		position[i] = len(grouped)
+		grouped = append(grouped, []string{brand})
 	}
 
-	// Default to standard
-	equipment.Category = data_structures.CategoryStandard
-	return equipment
+	return grouped, nil
 }
@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupBrands(t *testing.T) {
+	brands := []string{"Amana", "Carrier", "Daikin", "Goodman", "Trane"}
+
+	tests := []struct {
+		name    string
+		groups  [][]string
+		want    [][]string
+		wantErr bool
+	}{
+		{
+			name:   "no groups",
+			groups: nil,
+			want:   [][]string{{"Amana"}, {"Carrier"}, {"Daikin"}, {"Goodman"}, {"Trane"}},
+		},
+		{
+			// The group lands where its first brand is and lists only stocked brands
+			name:   "sister brands",
+			groups: [][]string{{"Goodman", "Amana", "Daikin", "Janitrol"}},
+			want:   [][]string{{"Amana", "Daikin", "Goodman"}, {"Carrier"}, {"Trane"}},
+		},
+		{
+			name:   "two groups",
+			groups: [][]string{{"Trane", "Carrier"}, {"Goodman", "Amana"}},
+			want:   [][]string{{"Amana", "Goodman"}, {"Carrier", "Trane"}, {"Daikin"}},
+		},
+		{
+			name:   "group with no stocked brands",
+			groups: [][]string{{"Rheem", "Ruud"}},
+			want:   [][]string{{"Amana"}, {"Carrier"}, {"Daikin"}, {"Goodman"}, {"Trane"}},
+		},
+		{
+			name:    "brand in two groups",
+			groups:  [][]string{{"Goodman", "Amana"}, {"Daikin", "Goodman"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GroupBrands(brands, tt.groups)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GroupBrands = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
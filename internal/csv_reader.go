@@ -6,62 +6,17 @@ import (
 	"io"
 	"log"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
 )
 
+// WriteOutputCSV writes the matches to filename using the default column layout.
 func WriteOutputCSV(matches []data_structures.OutputCSV, filename string) error {
-	// Create the output file
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
-	}
-	defer file.Close()
-
-	// Create a CSV writer
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	// Write the header row
-	header := []string{
-		"AHRI Number",
-		"Brand",
-		"Orientation",
-		"Type of System",
-		"Outdoor Unit",
-		"Furnace",
-		"Evaporator Coil",
-		"Air Handler",
-	}
-	if err := writer.Write(header); err != nil {
-		return fmt.Errorf("failed to write header: %w", err)
-	}
-
-	// Write each match as a row
-	for _, match := range matches {
-		row := []string{
-			match.AHRINumber,
-			match.Brand,
-			match.Orientation,
-			match.TypeOfSystem,
-			match.OutdoorUnit,
-			match.Furnace,
-			match.EvaporatorCoil,
-			match.AirHandler,
-		}
-		if err := writer.Write(row); err != nil {
-			return fmt.Errorf("failed to write row: %w", err)
-		}
-	}
-
-	// Check for any errors that occurred during writing
-	if err := writer.Error(); err != nil {
-		return fmt.Errorf("csv writer error: %w", err)
-	}
-
-	return nil
+	return WriteOutputLayout(matches, DefaultOutputLayout(), filename)
 }
+
 func GetCSVHeader(filename string, reqFields []string) (map[string]int, error) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -110,6 +65,17 @@ func CSVEquipReader(filename string, headers map[string]int) ([]data_structures.
 	equipmentList := []data_structures.Equipment{}
 	brandIdx := headers["brand"]
 
+	// Walk the equipment columns in file order so the equipment list is the same on every run
+	columns := make([]string, 0, len(headers))
+	for k := range headers {
+		if k != "brand" {
+			columns = append(columns, k)
+		}
+	}
+	sort.Slice(columns, func(i, j int) bool {
+		return headers[columns[i]] < headers[columns[j]]
+	})
+
 	for {
 		record, err := r.Read()
 		if err == io.EOF {
@@ -134,10 +100,8 @@ func CSVEquipReader(filename string, headers map[string]int) ([]data_structures.
 
 		brand := record[brandIdx]
 
-		for k, v := range headers {
-			if k == "brand" {
-				continue
-			}
+		for _, k := range columns {
+			v := headers[k]
 
 			if v >= len(record) {
 				continue
@@ -155,6 +119,99 @@ func CSVEquipReader(filename string, headers map[string]int) ([]data_structures.
 	return equipmentList, nil
 }
 
+/*
+ahriRatingColumn identifies which rating, if any, an AHRI export column holds.
+AHRI directory exports use long descriptive headers such as
+"Cooling Capacity (A2) - Single or High Stage (95F), btuh", so columns are
+matched on the key words they contain. Exports often have several columns for one
+rating (e.g. "Cooling Capacity (A2)" and "(B2)"); the reader keeps the leftmost.
+Water-to-air unit EER and COP columns are recognised by the loop condition they
+name, e.g. "EER (GLHP)" or "COP Ground Water".
+*/
+func ahriRatingColumn(header string) string {
+	h := strings.ToLower(strings.TrimSpace(header))
+	loop := waterLoopOf(h)
+
+	switch {
+	case strings.Contains(h, "seer2"):
+		return "seer2"
+	case strings.Contains(h, "eer2"):
+		return "eer2"
+	case strings.Contains(h, "hspf2"):
+		return "hspf2"
+	case loop != "" && strings.Contains(h, "eer"):
+		return "eer " + loop
+	case loop != "" && strings.Contains(h, "cop"):
+		return "cop " + loop
+	case loop != "":
+		// Capacities at each loop condition would overwrite one another
+		return ""
+	case strings.Contains(h, "cooling capacity"):
+		return "cooling capacity"
+	case strings.Contains(h, "heating capacity") && strings.Contains(h, "47"):
+		return "heating capacity 47"
+	case strings.Contains(h, "heating capacity") && strings.Contains(h, "17"):
+		return "heating capacity 17"
+	}
+	return ""
+}
+
+// ahriQualifierColumn identifies the manufacturer ("Outdoor Unit Brand Name",
+// "Manufacturer", ...) and program ("AHRI Program", "System Type", ...) columns.
+// Brand columns of the other components ("Indoor Unit Brand Name", ...) are not
+// the manufacturer: a system is certified under its outdoor unit's brand.
+func ahriQualifierColumn(header string) string {
+	h := strings.ToLower(strings.TrimSpace(header))
+	otherComponent := strings.Contains(h, "indoor") || strings.Contains(h, "furnace") ||
+		strings.Contains(h, "coil") || strings.Contains(h, "air handler")
+
+	switch {
+	case (strings.Contains(h, "manufacturer") || strings.Contains(h, "brand")) && !otherComponent:
+		return "manufacturer"
+	case strings.Contains(h, "program") || strings.Contains(h, "system type"):
+		return "program"
+	}
+	return ""
+}
+
+func setAHRIRating(ratings *data_structures.AHRIRatings, column string, value string) {
+	value = strings.TrimSpace(value)
+
+	switch column {
+	case "cooling capacity":
+		ratings.CoolingCapacity = value
+	case "seer2":
+		ratings.SEER2 = value
+	case "eer2":
+		ratings.EER2 = value
+	case "hspf2":
+		ratings.HSPF2 = value
+	case "heating capacity 47":
+		ratings.HeatingCapacity47 = value
+	case "heating capacity 17":
+		ratings.HeatingCapacity17 = value
+	case "eer " + LoopGround:
+		ratings.EERGroundLoop = value
+	case "cop " + LoopGround:
+		ratings.COPGroundLoop = value
+	case "eer " + LoopGroundWater:
+		ratings.EERGroundWater = value
+	case "cop " + LoopGroundWater:
+		ratings.COPGroundWater = value
+	case "eer " + LoopWater:
+		ratings.EERWaterLoop = value
+	case "cop " + LoopWater:
+		ratings.COPWaterLoop = value
+	}
+}
+
+/*
+CSVAHRIReader reads AHRI certification records.
+The first four columns are always AHRI Number, Outdoor Unit, Indoor Unit and Furnace.
+Any additional columns recognised as ratings (see ahriRatingColumn) are kept on the record,
+as are the manufacturer and program columns (see ahriQualifierColumn) and the further
+indoor heads of multi-split systems ("Indoor Unit 2" to "Indoor Unit 5", see ahriHeadColumn).
+*/
 func CSVAHRIReader(s string) ([]data_structures.AHRIRecord, error) {
 	file, err := os.Open(s)
 	if err != nil {
@@ -164,12 +221,55 @@ func CSVAHRIReader(s string) ([]data_structures.AHRIRecord, error) {
 
 	r := csv.NewReader(file)
 
-	_, err = r.Read()
+	header, err := r.Read()
 	if err != nil {
 		log.Printf("Error reading header: %v", err)
 		return []data_structures.AHRIRecord{}, err
 	}
 
+	// Rating columns beyond the four model columns, left to right, first column per
+	// rating, and the first manufacturer and program columns
+	type ratingColumn struct {
+		idx    int
+		rating string
+	}
+	ratingColumns := []ratingColumn{}
+	rated := make(map[string]bool)
+	manufacturerIdx, programIdx := -1, -1
+	headIdx := make([]int, MaxIndoorHeads+1) // head number -> column, 0 if not present
+	for i := 4; i < len(header); i++ {
+		if n := ahriHeadColumn(header[i]); n != 0 {
+			if headIdx[n] == 0 {
+				headIdx[n] = i
+			}
+			continue
+		}
+		if rating := ahriRatingColumn(header[i]); rating != "" {
+			if !rated[rating] {
+				rated[rating] = true
+				ratingColumns = append(ratingColumns, ratingColumn{idx: i, rating: rating})
+			}
+			continue
+		}
+		switch ahriQualifierColumn(header[i]) {
+		case "manufacturer":
+			if manufacturerIdx == -1 {
+				manufacturerIdx = i
+			}
+		case "program":
+			if programIdx == -1 {
+				programIdx = i
+			}
+		}
+	}
+
+	column := func(record []string, idx int) string {
+		if idx == -1 || idx >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[idx])
+	}
+
 	var AHRIList []data_structures.AHRIRecord
 
 	for {
@@ -187,6 +287,27 @@ func CSVAHRIReader(s string) ([]data_structures.AHRIRecord, error) {
 				}
 			}
 		}
+
+		line, _ := r.FieldPos(0)
+
+		ratings := data_structures.AHRIRatings{}
+		for _, c := range ratingColumns {
+			if c.idx < len(record) {
+				setAHRIRating(&ratings, c.rating, record[c.idx])
+			}
+		}
+
+		// Multi-split records list their further heads in the head columns
+		var heads []data_structures.Equipment
+		for _, idx := range headIdx[2:] {
+			if model := column(record, idx); idx != 0 && model != "" {
+				heads = append(heads, data_structures.Equipment{InputModelNumber: model})
+			}
+		}
+		if len(heads) > 0 {
+			heads = append([]data_structures.Equipment{{InputModelNumber: record[2]}}, heads...)
+		}
+
 		AHRIList = append(AHRIList, data_structures.AHRIRecord{
 			AHRINumber: record[0],
 			OutdoorUnit: data_structures.Equipment{
@@ -198,6 +319,11 @@ func CSVAHRIReader(s string) ([]data_structures.AHRIRecord, error) {
 			Furnace: data_structures.Equipment{
 				InputModelNumber: record[3],
 			},
+			IndoorHeads:  heads,
+			Ratings:      ratings,
+			Manufacturer: column(record, manufacturerIdx),
+			Program:      column(record, programIdx),
+			SourceRow:    line,
 		})
 	}
 	return AHRIList, nil
@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// writeTestCSV writes content to a csv file in a temporary directory
+func writeTestCSV(t *testing.T, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "input.csv")
+	if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestAHRIRatingColumn(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"Cooling Capacity (A2) - Single or High Stage (95F), btuh", "cooling capacity"},
+		{"SEER2", "seer2"},
+		{"EER2 (A2) - Single or High Stage (95F)", "eer2"},
+		{"HSPF2 (Region IV)", "hspf2"},
+		{"Heating Capacity (H12) - Single or High Stage (47F), btuh", "heating capacity 47"},
+		{"Heating Capacity (H32) - Single or High Stage (17F), btuh", "heating capacity 17"},
+		{"Heating Capacity", ""},
+		{"EER (GLHP)", "eer ground loop"},
+		{"COP Ground Water", "cop ground water"},
+		{"COP (WLHP)", "cop water loop"},
+		{"Cooling Capacity (GLHP)", ""},
+		{"Model Status", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := ahriRatingColumn(tt.header); got != tt.want {
+				t.Errorf("ahriRatingColumn(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVAHRIReaderRatings(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    data_structures.AHRIRatings
+	}{
+		{
+			name:    "no rating columns",
+			content: "AHRI,Outdoor,Indoor,Furnace\n1001,GSXN403010,CAPTA3026B4,\n",
+			want:    data_structures.AHRIRatings{},
+		},
+		{
+			name: "every rating",
+			content: "AHRI,Outdoor,Indoor,Furnace,Cooling Capacity (95F),SEER2,EER2,HSPF2,Heating Capacity (47F),Heating Capacity (17F)\n" +
+				"1001,GSZB403010,CAPTA3026B4,, 36000 ,15.2,12.1,7.8,35000,21000\n",
+			want: data_structures.AHRIRatings{
+				CoolingCapacity: "36000", SEER2: "15.2", EER2: "12.1", HSPF2: "7.8",
+				HeatingCapacity47: "35000", HeatingCapacity17: "21000",
+			},
+		},
+		{
+			name:    "leftmost of duplicate rating columns",
+			content: "AHRI,Outdoor,Indoor,Furnace,Cooling Capacity (A2),SEER2,Cooling Capacity (B2),SEER2 (alt)\n1001,GSXN403010,CAPTA3026B4,,36000,14.3,24000,13.0\n",
+			want:    data_structures.AHRIRatings{CoolingCapacity: "36000", SEER2: "14.3"},
+		},
+		{
+			name:    "water-to-air loop ratings",
+			content: "AHRI,Outdoor,Indoor,Furnace,Cooling Capacity (GLHP),EER (GLHP),COP (GLHP),EER (GWHP),COP (GWHP),EER (WLHP),COP (WLHP)\n1001,GTV036,,,36000,18.2,3.9,22.1,4.4,14.5,4.8\n",
+			want: data_structures.AHRIRatings{
+				EERGroundLoop: "18.2", COPGroundLoop: "3.9", EERGroundWater: "22.1",
+				COPGroundWater: "4.4", EERWaterLoop: "14.5", COPWaterLoop: "4.8",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Run several times: duplicate columns must resolve the same way every time
+			for range 5 {
+				records, err := CSVAHRIReader(writeTestCSV(t, tt.content))
+				if err != nil {
+					t.Fatalf("CSVAHRIReader: %v", err)
+				}
+				if len(records) != 1 || records[0].AHRINumber != "1001" {
+					t.Fatalf("records = %+v, want AHRI 1001 only", records)
+				}
+				if records[0].Ratings != tt.want {
+					t.Fatalf("ratings = %+v, want %+v", records[0].Ratings, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAHRIQualifierColumn(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"Outdoor Unit Brand Name", "manufacturer"},
+		{"Manufacturer", "manufacturer"},
+		{"Indoor Unit Brand Name", ""},
+		{"Furnace Brand Name", ""},
+		{"Coil Brand", ""},
+		{"Air Handler Brand", ""},
+		{"AHRI Program", "program"},
+		{"System Type", "program"},
+		{"Model Status", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := ahriQualifierColumn(tt.header); got != tt.want {
+				t.Errorf("ahriQualifierColumn(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVAHRIReaderQualifiers(t *testing.T) {
+	content := "AHRI,Outdoor,Indoor,Furnace,Indoor Unit Brand Name,Outdoor Unit Brand Name,SEER2,AHRI Program,Manufacturer\n" +
+		"1001,GSXN403010,CAPTA3026B4,,Amana, Goodman ,14.3,RCU-A-CB,Daikin\n" +
+		"1002,GSXN403010,CAPTA3026B4,,,,,,\n"
+
+	records, err := CSVAHRIReader(writeTestCSV(t, content))
+	if err != nil {
+		t.Fatalf("CSVAHRIReader: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("records = %+v, want 2", records)
+	}
+	// The first manufacturer column other than another component's brand
+	if records[0].Manufacturer != "Goodman" || records[0].Program != "RCU-A-CB" || records[0].Ratings.SEER2 != "14.3" {
+		t.Errorf("record = %+v, want Goodman, RCU-A-CB and SEER2 14.3", records[0])
+	}
+	if records[1].Manufacturer != "" || records[1].Program != "" {
+		t.Errorf("blank record = %+v, want no manufacturer or program", records[1])
+	}
+}
+
+func TestCSVEquipReaderOrder(t *testing.T) {
+	filename := writeTestCSV(t, "Brand,Furnace,Outdoor Unit (AC),Evaporator Coil\n"+
+		"Goodman,GR9S800803BN,GSXN403010,CAPTA3026B4\n"+
+		"Amana,,ASXN403010,CAPTA3026B4\n")
+	headers, err := GetCSVHeader(filename, []string{"Brand", "Furnace", "Outdoor Unit (AC)", "Evaporator Coil"})
+	if err != nil {
+		t.Fatalf("GetCSVHeader: %v", err)
+	}
+
+	want := []string{
+		"Goodman furnace GR9S800803BN",
+		"Goodman outdoor unit (ac) GSXN403010",
+		"Goodman evaporator coil CAPTA3026B4",
+		"Amana outdoor unit (ac) ASXN403010",
+		"Amana evaporator coil CAPTA3026B4",
+	}
+
+	// Rows, then columns, in file order on every run
+	for range 5 {
+		list, err := CSVEquipReader(filename, headers)
+		if err != nil {
+			t.Fatalf("CSVEquipReader: %v", err)
+		}
+		got := []string{}
+		for _, item := range list {
+			got = append(got, item.Brand+" "+item.Type+" "+item.InputModelNumber)
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("equipment = %v, want %v", got, want)
+		}
+	}
+
+	brands := SortedBrands(BrandIdentify([]data_structures.Equipment{{Brand: "Goodman"}, {Brand: "Amana"}, {Brand: "Goodman"}}))
+	if !slices.Equal(brands, []string{"Amana", "Goodman"}) {
+		t.Errorf("SortedBrands = %v, want [Amana Goodman]", brands)
+	}
+}
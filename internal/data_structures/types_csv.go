@@ -1,12 +1,99 @@
 package data_structures
 
 type OutputCSV struct {
-	AHRINumber     string
-	Brand          string
-	Orientation    string
-	TypeOfSystem   string
-	OutdoorUnit    string
-	Furnace        string
-	EvaporatorCoil string
-	AirHandler     string
+	AHRINumber     string `json:"ahri_number"`
+	Brand          string `json:"brand"`
+	Orientation    string `json:"orientation"`
+	TypeOfSystem   string `json:"type_of_system"`
+	Category       string `json:"category"` // "standard" or "communicating" (see systemCategory)
+	OutdoorUnit    string `json:"outdoor_unit"`
+	Furnace        string `json:"furnace"`
+	EvaporatorCoil string `json:"evaporator_coil"`
+	AirHandler     string `json:"air_handler"`
+
+	// IndoorHeads lists a mini-split system's indoor heads, in place of the
+	// evaporator coil or air handler
+	IndoorHeads []string `json:"indoor_heads,omitempty"`
+
+	// PackagedUnit is a packaged system's single cabinet unit
+	PackagedUnit string `json:"packaged_unit"`
+
+	// WaterToAirUnit is a geothermal or water-source system's water-to-air unit
+	WaterToAirUnit string `json:"water_to_air_unit"`
+
+	CoolingCapacity   string `json:"cooling_capacity"`
+	SEER2             string `json:"seer2"`
+	EER2              string `json:"eer2"`
+	HSPF2             string `json:"hspf2"`
+	HeatingCapacity47 string `json:"heating_capacity_47"`
+	HeatingCapacity17 string `json:"heating_capacity_17"`
+
+	// Water-to-air unit ratings at ground loop, ground water and water loop conditions
+	EERGroundLoop  string `json:"eer_ground_loop"`
+	COPGroundLoop  string `json:"cop_ground_loop"`
+	EERGroundWater string `json:"eer_ground_water"`
+	COPGroundWater string `json:"cop_ground_water"`
+	EERWaterLoop   string `json:"eer_water_loop"`
+	COPWaterLoop   string `json:"cop_water_loop"`
+
+	// Each component's own brand, which differs from Brand when sister
+	// brands are matched across a brand group
+	OutdoorUnitBrand string `json:"outdoor_unit_brand"`
+	IndoorUnitBrand  string `json:"indoor_unit_brand"`
+	FurnaceBrand     string `json:"furnace_brand"`
+
+	// RevisionTolerance lists the components certified only because the revision
+	// policy accepts any revision of the rated model
+	RevisionTolerance string `json:"revision_tolerance"`
+
+	// SupersededModels lists "old -> new" for each component certified through
+	// the AHRI listing of the model it replaced
+	SupersededModels string `json:"superseded_models"`
+
+	// Provenance: how the match was made ("exact", "wildcard", "revision tolerant",
+	// "cross-reference", or "heuristic" for systems matched by filters alone), the
+	// wildcard positions used, the resulting confidence and the AHRI file row (0 if none)
+	MatchMethod       string `json:"match_method"`
+	WildcardPositions string `json:"wildcard_positions"`
+	Confidence        string `json:"confidence"`
+	AHRISourceRow     int    `json:"ahri_source_row"`
+
+	// OtherAHRINumbers lists further references certifying the same
+	// combination when several are combined into one row
+	OtherAHRINumbers string `json:"other_ahri_numbers"`
+}
+
+// OutputColumn is a single column of an output layout. Field is either the
+// name of an OutputCSV field (e.g. "OutdoorUnit") or a text/template
+// expression evaluated against the OutputCSV row (e.g. "{{.Brand}} {{.OutdoorUnit}}").
+type OutputColumn struct {
+	Header string
+	Field  string
+}
+
+// OutputLayout describes how certified matches are written out. When Template
+// is set the matches are rendered free-form through text/template and Columns
+// is ignored.
+type OutputLayout struct {
+	Columns  []OutputColumn
+	Template string
+}
+
+// OutputFile describes one file produced when the output is split by brand and/or system type.
+type OutputFile struct {
+	Filename     string
+	Brand        string
+	TypeOfSystem string
+	Rows         int
+}
+
+// CompatibilityMatrix pivots the certified matches for one brand and system type
+// into a grid of outdoor units (rows) by indoor units (columns).
+// Cells[outdoor][indoor] holds the AHRI number(s) certifying that pairing.
+type CompatibilityMatrix struct {
+	Brand        string
+	TypeOfSystem string
+	OutdoorUnits []string
+	IndoorUnits  []string
+	Cells        map[string]map[string]string
 }
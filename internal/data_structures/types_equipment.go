@@ -1,11 +1,14 @@
 package data_structures
 
+import "time"
+
 type Equipment struct {
 	InputModelNumber      string
 	NormalizedModelNumber string
 	Brand                 string
 	Type                  string
-	Category              string // "standard" or "communicating"
+	Category              string // e.g. "standard" or "communicating"; any category may be configured
+	Orientation           string // "upflow", "downflow", "horizontal", "multiposition" or "" if unknown
 }
 
 const (
@@ -14,9 +17,76 @@ const (
 	TypeHeatPump    = "heat pump"
 	TypeEvapCoil    = "evaporator coil"
 	TypeAirHandler  = "air handler"
+
+	// Ductless (mini-split) equipment: one outdoor unit feeding one or more indoor heads
+	TypeMiniSplit       = "mini split"
+	TypeDuctlessWall    = "ductless wall"
+	TypeCeilingCassette = "ceiling cassette"
+	TypeDuctedSlim      = "ducted slim"
+
+	// Packaged units: single cabinet systems certified by themselves
+	TypePackagedAC          = "packaged unit (ac)"
+	TypePackagedHeatPump    = "packaged unit (hp)"
+	TypePackagedGasElectric = "packaged unit (gas/electric)"
+
+	// Geothermal and water-source heat pumps, alone or paired with an air handler
+	TypeWaterToAir = "water-to-air unit"
 )
 
+// Built in equipment categories. Categories are an open set: category rules may
+// assign any other name.
 const (
 	CategoryStandard      = "standard"
 	CategoryCommunicating = "communicating"
+	CategorySingleStage   = "single-stage"
+	CategoryTwoStage      = "two-stage"
+	CategoryVariableSpeed = "variable-speed"
+	CategoryInverter      = "inverter"
 )
+
+const (
+	OrientationUpflow        = "upflow"
+	OrientationDownflow      = "downflow"
+	OrientationHorizontal    = "horizontal"
+	OrientationMultiposition = "multiposition"
+)
+
+// OrientationRule maps the character at Position (zero-indexed, in the normalized
+// model number) of equipment whose type contains Type to an orientation.
+// A Code of "*" matches any character.
+type OrientationRule struct {
+	Type        string
+	Position    int
+	Code        string
+	Orientation string
+}
+
+// CategoryRule assigns Category to equipment of the Type role (e.g. "coil") whose
+// normalized model number contains Contains (case-insensitive). An empty Contains
+// matches every model of the role.
+type CategoryRule struct {
+	Type     string
+	Contains string
+	Category string
+}
+
+// RevisionRule is the model revision policy for one equipment family: models of the
+// Component ("outdoor unit", "indoor unit" or "furnace") starting with Prefix end in
+// Suffix characters of revision and voltage codes. With AnyRevision, any revision of a
+// certified model satisfies the certification; without it the full model must match.
+type RevisionRule struct {
+	Component   string
+	Prefix      string
+	Suffix      int
+	AnyRevision bool
+}
+
+// Supersession records that NewModel replaced OldModel from EffectiveDate on, so AHRI
+// certifications still listed under the old model apply to the new one. An empty Brand
+// applies to every brand; a zero EffectiveDate is always in effect.
+type Supersession struct {
+	Brand         string
+	OldModel      string
+	NewModel      string
+	EffectiveDate time.Time
+}
@@ -1,10 +1,69 @@
 package data_structures
 
+import "time"
+
 type AHRIRecord struct {
 	AHRINumber  string
 	OutdoorUnit Equipment
 	IndoorUnit  Equipment
 	Furnace     Equipment
+	Ratings     AHRIRatings
+
+	// IndoorHeads lists every indoor head of a multi-split certification, starting
+	// with IndoorUnit. It is empty for records with a single indoor unit.
+	IndoorHeads []Equipment
+
+	// Manufacturer and Program qualify the record when the AHRI export lists them;
+	// empty values certify the models for every brand and system type
+	Manufacturer string
+	Program      string
+
+	// SourceRow is the record's line in the AHRI file
+	SourceRow int
+}
+
+// AHRIIndexOptions controls how the AHRI index resolves model numbers.
+type AHRIIndexOptions struct {
+	// MultiWildcard, when non-zero, is the character manufacturers use for
+	// "any number of characters" ('*' always means exactly one character)
+	MultiWildcard byte
+
+	// Programs maps AHRI programs that aren't named after a system type to the
+	// system type names they certify
+	Programs map[string][]string
+
+	// ManufacturerAliases maps AHRI manufacturer names to the equipment list
+	// brands they certify, when the two are spelled differently
+	ManufacturerAliases map[string][]string
+
+	// RevisionRules replace the fixed length truncation for the equipment
+	// families they cover
+	RevisionRules []RevisionRule
+
+	// Supersessions let certifications of replaced models apply to their
+	// replacements; those not yet effective at AsOf are ignored
+	Supersessions []Supersession
+	AsOf          time.Time
+}
+
+// AHRIRatings holds the performance ratings published with an AHRI record.
+// Values are kept exactly as they appear in the AHRI export; any may be empty.
+type AHRIRatings struct {
+	CoolingCapacity   string
+	SEER2             string
+	EER2              string
+	HSPF2             string
+	HeatingCapacity47 string
+	HeatingCapacity17 string
+
+	// Water-to-air units are rated under ISO 13256-1 at each loop condition:
+	// closed ground loop, open loop ground water and boiler/tower water loop
+	EERGroundLoop  string
+	COPGroundLoop  string
+	EERGroundWater string
+	COPGroundWater string
+	EERWaterLoop   string
+	COPWaterLoop   string
 }
 
 type ComponentKey struct {
@@ -13,4 +72,80 @@ type ComponentKey struct {
 	IndoorUnit  Equipment
 	OutdoorUnit Equipment
 	SystemType  string
+
+	// IndoorHeads holds the one or more indoor heads of a mini-split system, which
+	// leaves IndoorUnit empty
+	IndoorHeads []Equipment
+}
+
+// MatchOptions controls the optional filters applied while finding certified matches.
+type MatchOptions struct {
+	// AllowedOrientations limits results to systems whose components all have one of
+	// these orientations. Components with an unknown orientation are never filtered.
+	// An empty list allows every orientation.
+	AllowedOrientations []string
+
+	// AHRIOutput chooses how a combination certified by several AHRI records is reported:
+	// "rows" (one row per reference, the default), "combined" (one row with the other
+	// references listed) or "primary" (one row with the primary reference only).
+	AHRIOutput string
+
+	// PrimaryAHRI picks the primary reference: "first" (input order, the default),
+	// "lowest number" or "highest rating" (SEER2).
+	PrimaryAHRI string
+
+	// VerifySystemTypes names the system types whose optional AHRI lookup runs.
+	// System types with an uncertified value (e.g. "central ac") are otherwise
+	// reported whenever they pass their filters, without an AHRI number; listed
+	// ones are looked up and those not certified are reported under the
+	// uncertified value instead.
+	VerifySystemTypes []string
+
+	// CategoryPairings lists the outdoor and indoor unit categories that may be
+	// paired with each other, besides equipment of the same category which always
+	// pairs. Combinations of other categories are never generated.
+	CategoryPairings []CategoryPairing
+
+	// Rejections, when non-nil, counts the combinations dropped by each filter.
+	Rejections map[string]int
+}
+
+// CategoryPairing allows outdoor units of the Outdoor category to be paired with
+// indoor units (and mini-split heads) of the Indoor category, e.g. a variable-speed
+// outdoor unit with a two-stage coil.
+type CategoryPairing struct {
+	Outdoor string
+	Indoor  string
+}
+
+// MatchJob is one brand (or brand group) and system type to generate combinations for and match.
+type MatchJob struct {
+	Brand      string // the brand, or the group's brands joined with " / "
+	SystemType string
+	Equipment  []Equipment // equipment for this brand or group only
+}
+
+// MatchResult is the outcome of a MatchJob.
+type MatchResult struct {
+	Job          MatchJob
+	Combinations int
+	Sample       []ComponentKey // first few combinations generated, for logging
+	Matches      []OutputCSV
+	Err          error
+}
+
+// Suggestion is a certified combination we stock that is close to a requested
+// combination which isn't certified.
+type Suggestion struct {
+	Combo    ComponentKey
+	Match    OutputCSV // the alternative as it would appear in the output
+	Changed  []string  // components that differ from the request: "outdoor unit", "indoor unit", "furnace"
+	Distance int       // total edit distance between the AHRI model keys
+}
+
+// LookupResult answers a lookup of one combination: the AHRI records certifying it,
+// or when there are none the closest certified alternatives we stock.
+type LookupResult struct {
+	Records     []AHRIRecord
+	Suggestions []Suggestion
 }
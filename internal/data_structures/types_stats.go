@@ -0,0 +1,32 @@
+package data_structures
+
+// RunStats is the machine readable summary written at the end of each run.
+type RunStats struct {
+	StartedAt  string            `json:"started_at"`
+	InputFiles map[string]string `json:"input_files"` // path -> sha256
+
+	EquipmentLoaded     int            `json:"equipment_loaded"`
+	EquipmentByBrand    map[string]int `json:"equipment_by_brand"`
+	EquipmentByCategory map[string]int `json:"equipment_by_category"`
+	AHRIRecordsLoaded   int            `json:"ahri_records_loaded"`
+	AHRIIndexEntries    int            `json:"ahri_index_entries"`
+
+	// CombinationsChecked and Rejections depend on the match engine: the certification
+	// engine only generates combinations that can be certified. The match counts don't.
+	CombinationsChecked int            `json:"combinations_checked"`
+	CertifiedMatches    int            `json:"certified_matches"`
+	HeuristicMatches    int            `json:"heuristic_matches"`          // rows reported by the filters alone
+	TonnageCompatible   int            `json:"tonnage_compatible_matches"` // verified rows with no AHRI record
+	MatchesByBrand      map[string]int `json:"matches_by_brand"`           // every row, certified or not
+	MatchesBySystemType map[string]int `json:"matches_by_system_type"`
+	MatchesByCategory   map[string]int `json:"matches_by_category"`
+	Rejections          map[string]int `json:"rejections"` // filter name -> combinations rejected
+
+	Stages []StageTiming `json:"stages"`
+}
+
+// StageTiming records how long one stage of the run took.
+type StageTiming struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
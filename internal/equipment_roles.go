@@ -0,0 +1,268 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+/*
+EquipmentRole describes one role equipment plays in a system: which equipment list
+types fill it, which component of a combination it fills and how it is reported.
+Every role the parser knows is registered in one place (see builtinEquipmentRoles
+and RegisterEquipmentRole); equipment is sorted into roles, system types are
+checked and output rows are filled from this registry alone.
+*/
+type EquipmentRole struct {
+	Name string // name system types refer to the role by, e.g. "coil"
+
+	// Matches reports whether an equipment list type (e.g. "evaporator coil") plays
+	// the role. Roles are tried in order and the first match wins.
+	Matches func(equipType string) bool
+
+	// Component is the part of a combination the role fills: ComponentOutdoorUnit,
+	// ComponentIndoorUnit or ComponentFurnace. Head roles are mini-split indoor
+	// heads, which fill the indoor unit component several at a time.
+	Component string
+	Head      bool
+
+	// Fill puts a model of the role in the output row
+	Fill func(output *data_structures.OutputCSV, model string)
+
+	// Columns, when set, returns the output columns the role is reported in that
+	// the default layout doesn't have, added when the equipment list has the role
+	Columns func() []data_structures.OutputColumn
+
+	// EquipmentType is the equipment list type models of the role that aren't
+	// stocked are normalized as
+	EquipmentType string
+}
+
+/*
+typeWords returns a role matcher for equipment types containing any of the phrases
+as whole words. Types are compared case insensitively with punctuation read as
+spaces, so "Outdoor Unit (AC)" has the word "ac" but "furnace" doesn't, and
+"mini-split" matches the phrase "mini split".
+*/
+func typeWords(phrases ...string) func(equipType string) bool {
+	return func(equipType string) bool {
+		words := " " + strings.Join(typeFields(equipType), " ") + " "
+		for _, phrase := range phrases {
+			if strings.Contains(words, " "+phrase+" ") {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// packagedType returns a role matcher for packaged equipment types with any of the
+// phrases as whole words (see typeWords), or with no phrases any packaged type
+func packagedType(phrases ...string) func(equipType string) bool {
+	packaged, kind := typeWords("packaged"), typeWords(phrases...)
+	return func(equipType string) bool {
+		return packaged(equipType) && (len(phrases) == 0 || kind(equipType))
+	}
+}
+
+// typeFields splits an equipment type into lower case words
+func typeFields(equipType string) []string {
+	return strings.FieldsFunc(strings.ToLower(equipType), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// The output fields roles are reported in
+var (
+	fillOutdoorUnit = func(output *data_structures.OutputCSV, model string) { output.OutdoorUnit = model }
+	fillFurnace     = func(output *data_structures.OutputCSV, model string) { output.Furnace = model }
+	fillCoil        = func(output *data_structures.OutputCSV, model string) { output.EvaporatorCoil = model }
+	fillAirHandler  = func(output *data_structures.OutputCSV, model string) { output.AirHandler = model }
+	fillIndoorHead  = func(output *data_structures.OutputCSV, model string) {
+		output.IndoorHeads = append(output.IndoorHeads, model)
+	}
+	fillPackagedUnit   = func(output *data_structures.OutputCSV, model string) { output.PackagedUnit = model }
+	fillWaterToAirUnit = func(output *data_structures.OutputCSV, model string) { output.WaterToAirUnit = model }
+
+	indoorHeadColumns = func() []data_structures.OutputColumn { return IndoorHeadColumns(IndoorHeadCount()) }
+)
+
+/*
+builtinEquipmentRoles returns the roles the parser ships with, in the order they are
+tried. Where a type has another role's key word as well as its own, the more specific
+role comes first: water-to-air and packaged units are tried before AC condensers and
+heat pumps ("geothermal heat pump" has the words "heat pump", "packaged unit (ac)"
+the word "ac"), and the packaged heat pump and gas/electric roles before the
+packaged AC role, which takes any other packaged unit.
+*/
+func builtinEquipmentRoles() []EquipmentRole {
+	return []EquipmentRole{
+		{
+			Name: waterToAirRole, Matches: typeWords("water to air", "geothermal", "water source"),
+			Component: ComponentOutdoorUnit, Fill: fillWaterToAirUnit, Columns: waterToAirColumns,
+			EquipmentType: data_structures.TypeWaterToAir,
+		},
+		{
+			Name: "packaged hp", Matches: packagedType("hp", "heat pump"),
+			Component: ComponentOutdoorUnit, Fill: fillPackagedUnit, Columns: PackagedUnitColumns,
+			EquipmentType: data_structures.TypePackagedHeatPump,
+		},
+		{
+			Name: "packaged gas", Matches: packagedType("gas"),
+			Component: ComponentOutdoorUnit, Fill: fillPackagedUnit, Columns: PackagedUnitColumns,
+			EquipmentType: data_structures.TypePackagedGasElectric,
+		},
+		{
+			Name: "packaged ac", Matches: packagedType(),
+			Component: ComponentOutdoorUnit, Fill: fillPackagedUnit, Columns: PackagedUnitColumns,
+			EquipmentType: data_structures.TypePackagedAC,
+		},
+		{
+			Name: "furnace", Matches: typeWords("furnace"),
+			Component: ComponentFurnace, Fill: fillFurnace,
+			EquipmentType: data_structures.TypeFurnace,
+		},
+		{
+			Name: "handler", Matches: typeWords("handler"),
+			Component: ComponentIndoorUnit, Fill: fillAirHandler,
+			EquipmentType: data_structures.TypeAirHandler,
+		},
+		{
+			Name: "coil", Matches: typeWords("coil"),
+			Component: ComponentIndoorUnit, Fill: fillCoil,
+			EquipmentType: data_structures.TypeEvapCoil,
+		},
+		{
+			Name: "mini split", Matches: typeWords("mini split"),
+			Component: ComponentOutdoorUnit, Fill: fillOutdoorUnit,
+			EquipmentType: data_structures.TypeMiniSplit,
+		},
+		{
+			Name: "wall", Matches: typeWords(data_structures.TypeDuctlessWall),
+			Component: ComponentIndoorUnit, Head: true, Fill: fillIndoorHead, Columns: indoorHeadColumns,
+			EquipmentType: data_structures.TypeDuctlessWall,
+		},
+		{
+			Name: "cassette", Matches: typeWords("cassette"),
+			Component: ComponentIndoorUnit, Head: true, Fill: fillIndoorHead, Columns: indoorHeadColumns,
+			EquipmentType: data_structures.TypeCeilingCassette,
+		},
+		{
+			Name: "slim", Matches: typeWords(data_structures.TypeDuctedSlim),
+			Component: ComponentIndoorUnit, Head: true, Fill: fillIndoorHead, Columns: indoorHeadColumns,
+			EquipmentType: data_structures.TypeDuctedSlim,
+		},
+		{
+			Name: "ac", Matches: typeWords("ac"),
+			Component: ComponentOutdoorUnit, Fill: fillOutdoorUnit,
+			EquipmentType: "outdoor unit (ac)",
+		},
+		{
+			Name: "hp", Matches: typeWords("hp", "heat pump"),
+			Component: ComponentOutdoorUnit, Fill: fillOutdoorUnit,
+			EquipmentType: "outdoor unit (hp)",
+		},
+	}
+}
+
+// roleRegistry holds every registered role in the order they are tried
+var roleRegistry []EquipmentRole
+
+// customRoles counts the roles added with RegisterEquipmentRole, which are tried
+// before the built in ones
+var customRoles int
+
+/*
+RegisterEquipmentRole adds a role system types can be built from. Registered roles
+are tried before the built in ones, in registration order, so a new, more specific
+equipment type can claim equipment a built in role would otherwise take. Register
+roles before the system types using them and before any matching starts.
+*/
+func RegisterEquipmentRole(role EquipmentRole) error {
+	role.Name = strings.ToLower(strings.TrimSpace(role.Name))
+	if role.Name == "" || role.Matches == nil || role.Fill == nil {
+		return fmt.Errorf("equipment role needs a name, a type matcher and an output field")
+	}
+	if _, exists := findEquipmentRole(role.Name); exists {
+		return fmt.Errorf("equipment role %s is already registered", role.Name)
+	}
+	switch role.Component {
+	case ComponentOutdoorUnit, ComponentFurnace:
+		if role.Head {
+			return fmt.Errorf("equipment role %s: indoor heads fill the %s component", role.Name, ComponentIndoorUnit)
+		}
+	case ComponentIndoorUnit:
+	default:
+		return fmt.Errorf("equipment role %s: unknown component %q", role.Name, role.Component)
+	}
+
+	roleRegistry = append(roleRegistry[:customRoles], append([]EquipmentRole{role}, roleRegistry[customRoles:]...)...)
+	customRoles++
+	return nil
+}
+
+// findEquipmentRole returns the registered role with the name
+func findEquipmentRole(name string) (*EquipmentRole, bool) {
+	for i := range roleRegistry {
+		if roleRegistry[i].Name == name {
+			return &roleRegistry[i], true
+		}
+	}
+	return nil, false
+}
+
+// equipmentRole maps an equipment type to the name of the role it plays in a system
+func equipmentRole(equipType string) (string, error) {
+	for _, role := range roleRegistry {
+		if role.Matches(equipType) {
+			return role.Name, nil
+		}
+	}
+	return "", fmt.Errorf("unknown equipment type: %s", equipType)
+}
+
+// roleComponent returns the component a role fills
+func roleComponent(name string) string {
+	if role, exists := findEquipmentRole(name); exists {
+		return role.Component
+	}
+	return ""
+}
+
+// hasRole reports whether the equipment list includes equipment of a role that passes check
+func hasRole(list []data_structures.Equipment, check func(role *EquipmentRole) bool) bool {
+	for _, item := range list {
+		name, err := equipmentRole(item.Type)
+		if err != nil {
+			continue
+		}
+		if role, _ := findEquipmentRole(name); check(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleColumns returns the output columns of every role the equipment list has that
+// the default layout doesn't cover, in role order, each column once
+func RoleColumns(list []data_structures.Equipment) []data_structures.OutputColumn {
+	columns := []data_structures.OutputColumn{}
+	seen := make(map[string]bool)
+
+	for i := range roleRegistry {
+		role := &roleRegistry[i]
+		if role.Columns == nil || !hasRole(list, func(r *EquipmentRole) bool { return r == role }) {
+			continue
+		}
+		for _, column := range role.Columns() {
+			if !seen[column.Header] {
+				seen[column.Header] = true
+				columns = append(columns, column)
+			}
+		}
+	}
+
+	return columns
+}
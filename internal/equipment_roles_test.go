@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// restoreRegistries puts the role and system type registries back as they were
+// when the test ends, for tests that register their own
+func restoreRegistries(t *testing.T) {
+	t.Helper()
+	roles, custom, systems := slices.Clone(roleRegistry), customRoles, slices.Clone(systemTypeRegistry)
+	t.Cleanup(func() {
+		roleRegistry, customRoles, systemTypeRegistry = roles, custom, systems
+	})
+}
+
+func TestEquipmentRole(t *testing.T) {
+	tests := []struct {
+		equipType string
+		want      string // "" for an unknown type
+	}{
+		{"furnace", "furnace"},
+		{"Furnace", "furnace"},
+		{"Outdoor Unit (AC)", "ac"},
+		{"outdoor unit (hp)", "hp"},
+		{data_structures.TypeACCondenser, "ac"},
+		{data_structures.TypeHeatPump, "hp"},
+		{data_structures.TypeEvapCoil, "coil"},
+		{data_structures.TypeAirHandler, "handler"},
+		{"Geothermal Heat Pump", waterToAirRole},
+		{data_structures.TypeWaterToAir, waterToAirRole},
+		{"Water Source Heat Pump", waterToAirRole},
+		{"Packaged AC", "packaged ac"},
+		{data_structures.TypePackagedAC, "packaged ac"},
+		{"Packaged Heat Pump", "packaged hp"},
+		{data_structures.TypePackagedHeatPump, "packaged hp"},
+		{data_structures.TypePackagedGasElectric, "packaged gas"},
+		{"Mini-Split", "mini split"},
+		{data_structures.TypeDuctlessWall, "wall"},
+		{data_structures.TypeCeilingCassette, "cassette"},
+		{"Ducted Slim Duct", "slim"},
+		// Key words only count as whole words
+		{"vacuum", ""},
+		{"boiler", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.equipType, func(t *testing.T) {
+			got, err := equipmentRole(tt.equipType)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("equipmentRole(%q) = %q, want an error", tt.equipType, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("equipmentRole(%q) = %q, %v, want %q", tt.equipType, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypeWords(t *testing.T) {
+	tests := []struct {
+		phrases   []string
+		equipType string
+		want      bool
+	}{
+		{[]string{"ac"}, "outdoor unit (ac)", true},
+		{[]string{"ac"}, "furnace", false},
+		{[]string{"ac"}, "packaged", false},
+		{[]string{"ac"}, "packaged unit (ac)", true},
+		{[]string{"heat pump"}, "Heat Pump", true},
+		{[]string{"heat pump"}, "heat pumps", false},
+		{[]string{"mini split"}, "mini-split outdoor unit", true},
+		{[]string{"hp", "heat pump"}, "outdoor unit (hp)", true},
+	}
+
+	for _, tt := range tests {
+		if got := typeWords(tt.phrases...)(tt.equipType); got != tt.want {
+			t.Errorf("typeWords(%q)(%q) = %v, want %v", tt.phrases, tt.equipType, got, tt.want)
+		}
+	}
+}
+
+func TestRegisterEquipmentRole(t *testing.T) {
+	restoreRegistries(t)
+
+	boiler := EquipmentRole{
+		Name: "Boiler", Matches: typeWords("boiler"),
+		Component: ComponentFurnace, Fill: fillFurnace,
+	}
+	if err := RegisterEquipmentRole(boiler); err != nil {
+		t.Fatalf("RegisterEquipmentRole: %v", err)
+	}
+	if role, err := equipmentRole("hot water boiler"); err != nil || role != "boiler" {
+		t.Errorf("equipmentRole = %q, %v, want boiler", role, err)
+	}
+
+	// Registered roles are tried first, so they can claim a built in role's types
+	ductless := EquipmentRole{
+		Name: "ductless ac", Matches: typeWords("ductless"),
+		Component: ComponentOutdoorUnit, Fill: fillOutdoorUnit,
+	}
+	if err := RegisterEquipmentRole(ductless); err != nil {
+		t.Fatalf("RegisterEquipmentRole: %v", err)
+	}
+	if role, _ := equipmentRole("ductless ac"); role != "ductless ac" {
+		t.Errorf("equipmentRole = %q, want the registered ductless ac", role)
+	}
+	if roleComponent("boiler") != ComponentFurnace {
+		t.Errorf("roleComponent(boiler) = %q, want %q", roleComponent("boiler"), ComponentFurnace)
+	}
+
+	invalid := []struct {
+		name string
+		role EquipmentRole
+	}{
+		{"already registered", boiler},
+		{"built in name", EquipmentRole{Name: "coil", Matches: typeWords("coil"), Component: ComponentIndoorUnit, Fill: fillCoil}},
+		{"no matcher", EquipmentRole{Name: "heater", Component: ComponentFurnace, Fill: fillFurnace}},
+		{"unknown component", EquipmentRole{Name: "humidifier", Matches: typeWords("humidifier"), Component: "accessory", Fill: fillFurnace}},
+		{"outdoor head", EquipmentRole{Name: "head unit", Matches: typeWords("head"), Component: ComponentOutdoorUnit, Head: true, Fill: fillIndoorHead}},
+	}
+	for _, tt := range invalid {
+		if err := RegisterEquipmentRole(tt.role); err == nil {
+			t.Errorf("%s: registered, want an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// waterToAirRole is the role of geothermal and water-source heat pumps: the
+// water-to-air unit holding the compressor, which fills the outdoor unit slot of
+// a combination whether it is a single cabinet or paired with an air handler
+const waterToAirRole = "water to air"
+
+// Loop conditions water-to-air units are rated at under ISO 13256-1, for the
+// geothermal loops setting in main.go
+const (
+	LoopGround      = "ground loop"  // closed ground loop (GLHP)
+	LoopGroundWater = "ground water" // open loop well water (GWHP)
+	LoopWater       = "water loop"   // boiler/tower water loop (WLHP)
+)
+
+// waterLoops lists the loop conditions in the order their columns are written
+var waterLoops = []string{LoopGround, LoopGroundWater, LoopWater}
+
+// waterLoopOf returns the loop condition an AHRI column header names, or ""
+func waterLoopOf(header string) string {
+	switch {
+	case strings.Contains(header, "glhp") || strings.Contains(header, "ground loop") || strings.Contains(header, "closed loop"):
+		return LoopGround
+	case strings.Contains(header, "gwhp") || strings.Contains(header, "ground water") || strings.Contains(header, "open loop"):
+		return LoopGroundWater
+	case strings.Contains(header, "wlhp") || strings.Contains(header, "water loop"):
+		return LoopWater
+	}
+	return ""
+}
+
+// waterToAirColumns returns the column showing water-to-air units, added to the
+// default layout when the equipment list has water-to-air units
+func waterToAirColumns() []data_structures.OutputColumn {
+	return []data_structures.OutputColumn{
+		{Header: "Water-to-Air Unit", Field: "WaterToAirUnit"},
+	}
+}
+
+// HasWaterToAirUnits reports whether the equipment list includes any water-to-air units
+func HasWaterToAirUnits(list []data_structures.Equipment) bool {
+	return hasRole(list, func(role *EquipmentRole) bool {
+		return role.Name == waterToAirRole
+	})
+}
+
+/*
+WaterLoopColumns returns an EER and COP column for each of the loop conditions given
+(LoopGround, LoopGroundWater, LoopWater), added to the default layout after the
+water-to-air unit column when the equipment list has water-to-air units.
+*/
+func WaterLoopColumns(loops []string) ([]data_structures.OutputColumn, error) {
+	columns := []data_structures.OutputColumn{}
+
+	for _, loop := range loops {
+		switch strings.ToLower(strings.TrimSpace(loop)) {
+		case LoopGround:
+			columns = append(columns,
+				data_structures.OutputColumn{Header: "EER (Ground Loop)", Field: "EERGroundLoop"},
+				data_structures.OutputColumn{Header: "COP (Ground Loop)", Field: "COPGroundLoop"})
+		case LoopGroundWater:
+			columns = append(columns,
+				data_structures.OutputColumn{Header: "EER (Ground Water)", Field: "EERGroundWater"},
+				data_structures.OutputColumn{Header: "COP (Ground Water)", Field: "COPGroundWater"})
+		case LoopWater:
+			columns = append(columns,
+				data_structures.OutputColumn{Header: "EER (Water Loop)", Field: "EERWaterLoop"},
+				data_structures.OutputColumn{Header: "COP (Water Loop)", Field: "COPWaterLoop"})
+		default:
+			return nil, fmt.Errorf("unknown water loop: %s (want one of %s)", loop, strings.Join(waterLoops, ", "))
+		}
+	}
+
+	return columns, nil
+}
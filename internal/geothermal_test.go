@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+func TestWaterLoopColumns(t *testing.T) {
+	tests := []struct {
+		loops   []string
+		want    []string // column headers
+		wantErr bool
+	}{
+		{loops: nil, want: []string{}},
+		{loops: []string{"ground loop"}, want: []string{"EER (Ground Loop)", "COP (Ground Loop)"}},
+		{loops: []string{" Water Loop ", LoopGroundWater}, want: []string{"EER (Water Loop)", "COP (Water Loop)", "EER (Ground Water)", "COP (Ground Water)"}},
+		{loops: []string{"lake loop"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		columns, err := WaterLoopColumns(tt.loops)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("WaterLoopColumns(%q): err = %v, wantErr %v", tt.loops, err, tt.wantErr)
+		}
+		if tt.wantErr {
+			continue
+		}
+		got := []string{}
+		for _, column := range columns {
+			got = append(got, column.Header)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("WaterLoopColumns(%q) = %v, want %v", tt.loops, got, tt.want)
+		}
+	}
+}
+
+func TestGeothermalMatches(t *testing.T) {
+	list := engineTestEquipment()
+	if HasWaterToAirUnits(list) {
+		t.Fatal("HasWaterToAirUnits reports units the list doesn't have")
+	}
+	for _, s := range []struct{ equipType, model string }{
+		{"Geothermal Heat Pump", "GTV036"},
+		{data_structures.TypeWaterToAir, "GTS036"},
+	} {
+		item := NormalizeString(data_structures.Equipment{InputModelNumber: s.model, Brand: "Goodman", Type: s.equipType})
+		list = append(list, CategorizeEquipment(item, DefaultCategoryRules()))
+	}
+	if !HasWaterToAirUnits(list) {
+		t.Fatal("HasWaterToAirUnits missed the water-to-air units")
+	}
+
+	record := func(number, unit, handler string) data_structures.AHRIRecord {
+		return data_structures.AHRIRecord{
+			AHRINumber:  number,
+			OutdoorUnit: data_structures.Equipment{InputModelNumber: unit},
+			IndoorUnit:  data_structures.Equipment{InputModelNumber: handler},
+			Ratings:     data_structures.AHRIRatings{EERGroundLoop: "18.2", COPGroundLoop: "3.9"},
+		}
+	}
+	ahriIndex := testAHRIIndex(t, append(engineTestRecords(),
+		record("4001", "GTV036", ""),
+		record("4002", "GTS036", "AMST30BU1300"),
+	), 0)
+
+	tests := []struct {
+		sysType string
+		want    []string // AHRI number, water-to-air unit and air handler of each match
+	}{
+		{"geothermal heat pump", []string{"4001 GTV036 "}},
+		{"geothermal heat pump & air handler", []string{"4002 GTS036 AMST30BU1300"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sysType, func(t *testing.T) {
+			cartesian, err := GenerateFullSystemEquipmentConfig(context.Background(), list, tt.sysType, nil)
+			if err != nil {
+				t.Fatalf("cartesian engine: %v", err)
+			}
+			certified, err := GenerateCertifiedSystemEquipmentConfig(context.Background(), list, tt.sysType, ahriIndex, nil, nil)
+			if err != nil {
+				t.Fatalf("certification engine: %v", err)
+			}
+
+			want, err := FindCertifiedMatches(context.Background(), slices.Values(cartesian), ahriIndex, data_structures.MatchOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := FindCertifiedMatches(context.Background(), slices.Values(certified), ahriIndex, data_structures.MatchOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("certification engine matches differ\ngot:  %+v\nwant: %+v", got, want)
+			}
+
+			// The unit is reported in its own column, with its loop ratings
+			matched := []string{}
+			for _, match := range want {
+				if match.OutdoorUnit != "" || match.EERGroundLoop != "18.2" || match.COPGroundLoop != "3.9" {
+					t.Errorf("match %+v: want no outdoor unit and the ground loop ratings", match)
+				}
+				matched = append(matched, match.AHRINumber+" "+match.WaterToAirUnit+" "+match.AirHandler)
+			}
+			if !slices.Equal(matched, tt.want) {
+				t.Errorf("matches = %v, want %v", matched, tt.want)
+			}
+		})
+	}
+}
@@ -1,381 +1,525 @@
 package internal
 
 import (
+	"context"
 	"fmt"
+	"iter"
+	"slices"
+	"sort"
 	"strings"
 
 	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
 )
 
-var systemTypes = map[string]string{
-	"central ac":               "central_ac",
-	"central ac & air handler": "central_ac_air_handler",
-	"central ac & furnace":     "central_ac_furnace",
-	"heat pump & air handler":  "air_source_heat_pump_electric_heat",
-	"heat pump & furnace":      "air_source_heat_pump_furnace",
-	"heat pump":                "air_source_heat_pump",
-	"furnace":                  "furnace",
-}
+// Filter names used to count rejected combinations (see MatchOptions.Rejections)
+const (
+	RejectOrientation       = "orientation"
+	RejectIndoorUnit        = "indoor unit"
+	RejectTonnage           = "tonnage"
+	RejectCabinetAndTonnage = "cabinet and tonnage"
+	RejectNotCertified      = "not certified"
+)
+
+// Values for MatchOptions.AHRIOutput
+const (
+	AHRIOutputRows     = "rows"
+	AHRIOutputCombined = "combined"
+	AHRIOutputPrimary  = "primary"
+)
+
+// Values for MatchOptions.PrimaryAHRI
+const (
+	PrimaryAHRIFirst         = "first"
+	PrimaryAHRILowestNumber  = "lowest number"
+	PrimaryAHRIHighestRating = "highest rating"
+)
+
+// cancelCheckInterval is how many combinations are checked between context polls
+const cancelCheckInterval = 1024
 
 /*
 GenerateFullSystemEquipmentConfig generates a Cartesian product of equipment combinations.
-It now separates standard and communicating equipment to ensure proper pairing.
-Equipment list provided must all be from the same brand.
+Outdoor units are only paired with indoor units of their own category or one the
+pairings allow (see MatchOptions.CategoryPairings).
+Equipment list provided must all be from the same brand (or brand group).
 */
-func GenerateFullSystemEquipmentConfig(list []data_structures.Equipment, sysType string) ([]data_structures.ComponentKey, error) {
+func GenerateFullSystemEquipmentConfig(
+	ctx context.Context,
+	list []data_structures.Equipment,
+	sysType string,
+	pairings []data_structures.CategoryPairing,
+) ([]data_structures.ComponentKey, error) {
+	combos, err := GenerateSystemEquipmentConfigSeq(ctx, list, sysType, pairings)
+	if err != nil {
+		return nil, err
+	}
+
+	equipConfigs := slices.Collect(combos)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return equipConfigs, nil
+}
+
+/*
+GenerateSystemEquipmentConfigSeq is the streaming form of GenerateFullSystemEquipmentConfig.
+Combinations are produced one at a time as the caller ranges over the sequence, in the
+same order, so memory stays constant however large the product is. Unknown equipment
+types are reported up front; if ctx is cancelled the sequence simply ends early.
+Multi-zone mini-splits enumerate every set of heads, which grows with the fifth power
+of the heads stocked; StreamMatchJob generates those from the AHRI index instead.
+*/
+func GenerateSystemEquipmentConfigSeq(
+	ctx context.Context,
+	list []data_structures.Equipment,
+	sysType string,
+	pairings []data_structures.CategoryPairing,
+) (iter.Seq[data_structures.ComponentKey], error) {
+	system, known := findSystemType(sysType)
+	if !known {
+		return nil, fmt.Errorf("unknown system type: %s", sysType)
+	}
+
 	// Create nested map: equipByTypeAndCategory[type][category][]Equipment
 	equipByTypeAndCategory := make(map[string]map[string][]data_structures.Equipment)
 
-	types := []string{"furnace", "handler", "coil", "ac", "hp"}
-	categories := []string{data_structures.CategoryStandard, data_structures.CategoryCommunicating}
-
-	// Initialize nested maps
-	for _, t := range types {
-		equipByTypeAndCategory[t] = make(map[string][]data_structures.Equipment)
-		for _, c := range categories {
-			equipByTypeAndCategory[t][c] = []data_structures.Equipment{}
-		}
+	for _, role := range roleRegistry {
+		equipByTypeAndCategory[role.Name] = make(map[string][]data_structures.Equipment)
 	}
 
 	// Sort equipment by type and category
 	for _, item := range list {
-		if strings.Contains(item.Type, "furnace") {
-			equipByTypeAndCategory["furnace"][item.Category] = append(
-				equipByTypeAndCategory["furnace"][item.Category], item)
-		} else if strings.Contains(item.Type, "handler") {
-			equipByTypeAndCategory["handler"][item.Category] = append(
-				equipByTypeAndCategory["handler"][item.Category], item)
-		} else if strings.Contains(item.Type, "coil") {
-			equipByTypeAndCategory["coil"][item.Category] = append(
-				equipByTypeAndCategory["coil"][item.Category], item)
-		} else if strings.Contains(item.Type, "ac") {
-			equipByTypeAndCategory["ac"][item.Category] = append(
-				equipByTypeAndCategory["ac"][item.Category], item)
-		} else if strings.Contains(item.Type, "hp") {
-			equipByTypeAndCategory["hp"][item.Category] = append(
-				equipByTypeAndCategory["hp"][item.Category], item)
-		} else {
-			return nil, fmt.Errorf("unknown equipment type: %s", item.Type)
-		}
-	}
-
-	equipConfigs := make([]data_structures.ComponentKey, 0)
-
-	// Generate combinations for each category separately
-	// This ensures communicating equipment only pairs with communicating equipment
-	for _, category := range categories {
-		combos, err := generateCombosForCategory(
-			equipByTypeAndCategory,
-			category,
-			sysType,
-		)
+		role, err := equipmentRole(item.Type)
 		if err != nil {
 			return nil, err
 		}
-		equipConfigs = append(equipConfigs, combos...)
+		equipByTypeAndCategory[role][item.Category] = append(
+			equipByTypeAndCategory[role][item.Category], item)
 	}
 
-	return equipConfigs, nil
+	categories := EquipmentCategories(list)
+	pairs := categoryPairing(pairings)
+
+	return func(yield func(data_structures.ComponentKey) bool) {
+		generateCombos(ctx, equipByTypeAndCategory, categories, pairs, system, yield)
+	}, nil
 }
 
-// generateCombosForCategory creates equipment combinations within a single category
-// This ensures standard equipment doesn't mix with communicating equipment
-// Note: Furnaces are shared across categories since they work with both types
-func generateCombosForCategory(
+/*
+generateCombos creates the system type's combinations from its roles: outdoor unit,
+then furnace, then indoor unit. Outdoor units are taken a category at a time, in
+category order, and only paired with indoor units (or heads) of the categories pairs
+allows; furnaces work with every category. Systems without an outdoor unit have no
+category to pair by and are generated once. Each combination is passed to yield;
+it returns false once yield asks to stop or ctx is cancelled.
+*/
+func generateCombos(
+	ctx context.Context,
 	equipMap map[string]map[string][]data_structures.Equipment,
-	category string,
-	sysType string,
-) ([]data_structures.ComponentKey, error) {
-
-	equipConfigs := make([]data_structures.ComponentKey, 0)
-
-	// Get equipment for this category
-	// Furnaces are shared - combine both standard and communicating (though typically all standard)
-	furnaces := append(equipMap["furnace"][data_structures.CategoryStandard],
-		equipMap["furnace"][data_structures.CategoryCommunicating]...)
-	airHandlers := equipMap["handler"][category]
-	coils := equipMap["coil"][category]
-	airCons := equipMap["ac"][category]
-	heatPumps := equipMap["hp"][category]
-
-	switch sysType {
-	case "heat pump & air handler":
-		for _, hp := range heatPumps {
-			for _, ah := range airHandlers {
-				equipConfigs = append(equipConfigs, data_structures.ComponentKey{
-					Brand:       hp.Brand,
-					IndoorUnit:  ah,
-					OutdoorUnit: hp,
-					SystemType:  systemTypes["heat pump & air handler"],
-				})
+	categories []string,
+	pairs func(outdoor string, indoor string) bool,
+	system *SystemType,
+	yield func(data_structures.ComponentKey) bool,
+) bool {
+	// roleEquipment lists a role's equipment of the accepted categories, in category order
+	roleEquipment := func(role string, accept func(category string) bool) []data_structures.Equipment {
+		equipment := []data_structures.Equipment{}
+		for _, category := range categories {
+			if accept(category) {
+				equipment = append(equipment, equipMap[role][category]...)
 			}
 		}
-		return equipConfigs, nil
-
-	case "heat pump & furnace":
-		for _, hp := range heatPumps {
-			for _, f := range furnaces {
-				for _, c := range coils {
-					equipConfigs = append(equipConfigs, data_structures.ComponentKey{
-						Brand:       hp.Brand,
-						IndoorUnit:  c,
-						Furnace:     f,
-						OutdoorUnit: hp,
-						SystemType:  systemTypes["heat pump & furnace"],
-					})
-				}
-			}
+		return equipment
+	}
+	anyCategory := func(string) bool { return true }
+
+	// A component the system doesn't have is a single empty slot
+	none := []data_structures.Equipment{{}}
+	furnaces := none
+	if system.Furnace {
+		furnaces = roleEquipment("furnace", anyCategory)
+	}
+
+	if system.Outdoor == "" {
+		indoors := none
+		if system.Indoor != "" {
+			indoors = roleEquipment(system.Indoor, anyCategory)
 		}
-		return equipConfigs, nil
-
-	case "central ac & furnace":
-		for _, ac := range airCons {
-			for _, f := range furnaces {
-				for _, c := range coils {
-					equipConfigs = append(equipConfigs, data_structures.ComponentKey{
-						Brand:       ac.Brand,
-						IndoorUnit:  c,
-						Furnace:     f,
-						OutdoorUnit: ac,
-						SystemType:  systemTypes["central ac & furnace"],
-					})
-				}
-			}
+		return generatePairings(ctx, none, furnaces, indoors, system, yield)
+	}
+
+	for _, category := range categories {
+		outdoors := equipMap[system.Outdoor][category]
+		if len(outdoors) == 0 {
+			continue
+		}
+		pairsWith := func(indoor string) bool {
+			return pairs(category, indoor)
 		}
-		return equipConfigs, nil
-
-	case "central ac & air handler":
-		for _, ac := range airCons {
-			for _, ah := range airHandlers {
-				equipConfigs = append(equipConfigs, data_structures.ComponentKey{
-					Brand:       ac.Brand,
-					IndoorUnit:  ah,
-					OutdoorUnit: ac,
-					SystemType:  systemTypes["central ac & air handler"],
-				})
+
+		if len(system.Heads) > 0 {
+			heads := []data_structures.Equipment{}
+			for _, role := range system.Heads {
+				heads = append(heads, roleEquipment(role, pairsWith)...)
+			}
+			if !generateHeadCombos(ctx, outdoors, heads, system, yield) {
+				return false
 			}
+			continue
 		}
-		return equipConfigs, nil
-
-	case "furnace":
-		for _, f := range furnaces {
-			equipConfigs = append(equipConfigs, data_structures.ComponentKey{
-				Brand:      f.Brand,
-				Furnace:    f,
-				SystemType: systemTypes["furnace"],
-			})
+
+		indoors := none
+		if system.Indoor != "" {
+			indoors = roleEquipment(system.Indoor, pairsWith)
 		}
-		return equipConfigs, nil
-
-	case "central ac":
-		for _, ac := range airCons {
-			for _, c := range coils {
-				equipConfigs = append(equipConfigs, data_structures.ComponentKey{
-					Brand:       ac.Brand,
-					IndoorUnit:  c,
-					OutdoorUnit: ac,
-					SystemType:  systemTypes["central ac"],
-				})
-			}
+		if !generatePairings(ctx, outdoors, furnaces, indoors, system, yield) {
+			return false
 		}
-		return equipConfigs, nil
 	}
 
-	return equipConfigs, nil
+	return true
 }
 
-func expandFurnaceWildcard(model string) []string {
-	// If the model doesn't have a wildcard, return it as-is
-	if !strings.Contains(model, "*") {
-		return []string{model}
+// generatePairings yields every outdoor x furnace x indoor combination of the equipment given
+func generatePairings(
+	ctx context.Context,
+	outdoors, furnaces, indoors []data_structures.Equipment,
+	system *SystemType,
+	yield func(data_structures.ComponentKey) bool,
+) bool {
+	for _, outdoor := range outdoors {
+		for _, furnace := range furnaces {
+			if ctx.Err() != nil {
+				return false
+			}
+			for _, indoor := range indoors {
+				combo := data_structures.ComponentKey{
+					OutdoorUnit: outdoor,
+					IndoorUnit:  indoor,
+					Furnace:     furnace,
+					SystemType:  system.Value,
+				}
+				combo.Brand = comboBrand(combo)
+				if !yield(combo) {
+					return false
+				}
+			}
+		}
 	}
 
-	// The wildcard is always in position 1 (second character, zero-indexed)
-	// Generate both possible variations
-	runes := []rune(model)
+	return true
+}
+
+// generateHeadCombos yields each mini-split outdoor unit with every set of the
+// indoor heads given that the system type allows
+func generateHeadCombos(
+	ctx context.Context,
+	outdoors, heads []data_structures.Equipment,
+	system *SystemType,
+	yield func(data_structures.ComponentKey) bool,
+) bool {
+	checked := 0
+	for _, outdoor := range outdoors {
+		more := forEachHeadSet(heads, system.MinHeads, system.MaxHeads, func(set []data_structures.Equipment) bool {
+			if checked%cancelCheckInterval == 0 && ctx.Err() != nil {
+				return false
+			}
+			checked++
 
-	// Find the wildcard position (should be position 1, but let's be safe)
-	wildcardPos := -1
-	for i, char := range runes {
-		if char == '*' {
-			wildcardPos = i
-			break
+			return yield(data_structures.ComponentKey{
+				Brand:       outdoor.Brand,
+				OutdoorUnit: outdoor,
+				IndoorHeads: set,
+				SystemType:  system.Value,
+			})
+		})
+		if !more {
+			return false
 		}
 	}
 
-	if wildcardPos == -1 {
-		return []string{model}
+	return true
+}
+
+// comboBrand is the brand a combination is reported under: its outdoor unit's,
+// or for systems without one its furnace's, then its indoor unit's
+func comboBrand(combo data_structures.ComponentKey) string {
+	for _, equip := range []data_structures.Equipment{combo.OutdoorUnit, combo.Furnace, combo.IndoorUnit} {
+		if equip.InputModelNumber != "" {
+			return equip.Brand
+		}
 	}
+	return ""
+}
 
-	// Create both variations: one with 'R', one with 'D'
-	variation1 := make([]rune, len(runes))
-	copy(variation1, runes)
-	variation1[wildcardPos] = 'R'
+func FindAHRICertification(config data_structures.ComponentKey, ahriIndex *AHRIIndex) ([]data_structures.AHRIRecord, bool) {
+	// Build the lookup keys from normalized model numbers, or as the revision policy directs
+	keys := ahriIndex.comboKeys(config)
 
-	variation2 := make([]rune, len(runes))
-	copy(variation2, runes)
-	variation2[wildcardPos] = 'D'
+	// Look them up in the index for this brand and system type, resolving any AHRI wildcards against the keys
+	records, certified := ahriIndex.Lookup(config.Brand, config.SystemType, keys...)
+	if certified {
+		return records, true
+	}
 
-	return []string{string(variation1), string(variation2)}
+	// AHRI may still list the models our stock replaced
+	return ahriIndex.lookupSuperseded(config)
 }
 
-func expandIndoorUnitWildcard(model string) []string {
-	// If no wildcards, return as-is
-	if !strings.Contains(model, "*") {
-		return []string{model}
+/*
+findCentralACCertification looks a condenser and coil system up in the AHRI index:
+records listing the pair with no furnace first, then records listing it with any furnace.
+*/
+func findCentralACCertification(combo data_structures.ComponentKey, ahriIndex *AHRIIndex) ([]data_structures.AHRIRecord, bool) {
+	records, _ := FindAHRICertification(combo, ahriIndex)
+	seen := make(map[string]bool)
+	for _, record := range records {
+		seen[record.AHRINumber] = true
 	}
 
-	runes := []rune(model)
+	outdoor := ahriIndex.modelKey(ComponentOutdoorUnit, combo.OutdoorUnit)
+	indoor := ahriIndex.modelKey(ComponentIndoorUnit, combo.IndoorUnit)
 
-	// Find all wildcard positions
-	wildcardPositions := []int{}
-	for i, char := range runes {
-		if char == '*' {
-			wildcardPositions = append(wildcardPositions, i)
+	// Pairs are usually listed under the furnace system they were rated in
+	for _, sysType := range []string{combo.SystemType, systemTypeValue("central ac & furnace")} {
+		withFurnace, _ := ahriIndex.LookupAnyFurnace(combo.Brand, sysType, outdoor, indoor)
+		for _, record := range withFurnace {
+			if !seen[record.AHRINumber] {
+				seen[record.AHRINumber] = true
+				records = append(records, record)
+			}
 		}
 	}
 
-	// Handle the first wildcard (position 2, zero-indexed) - always 'P'
-	for _, pos := range wildcardPositions {
-		if pos == 2 { // Third character
-			runes[pos] = 'P'
-		}
+	return records, len(records) > 0
+}
+
+/*
+FindCertifiedMatches filters the combinations and looks each one up in the AHRI index,
+returning a row for every certified match. Combinations are consumed lazily, so a
+sequence from GenerateSystemEquipmentConfigSeq is never held in memory as a whole.
+*/
+func FindCertifiedMatches(
+	ctx context.Context,
+	fullSystemCombos iter.Seq[data_structures.ComponentKey],
+	ahriIndex *AHRIIndex,
+	opts data_structures.MatchOptions,
+) ([]data_structures.OutputCSV, error) {
+	certifiedMatches := make([]data_structures.OutputCSV, 0)
+
+	_, err := StreamCertifiedMatches(ctx, fullSystemCombos, ahriIndex, opts, func(output data_structures.OutputCSV) error {
+		certifiedMatches = append(certifiedMatches, output)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Find the second wildcard (second from last position)
-	// After normalization, this should be at len(runes) - 2
-	secondWildcardPos := -1
-	for _, pos := range wildcardPositions {
-		if pos == len(runes)-2 {
-			secondWildcardPos = pos
-			break
-		}
+	return certifiedMatches, nil
+}
+
+/*
+StreamCertifiedMatches is the streaming form of FindCertifiedMatches: each certified
+match is passed to emit as soon as it is found instead of being collected, so memory
+stays constant. It returns the number of combinations checked. An error from emit or
+a cancelled ctx stops the stream.
+*/
+func StreamCertifiedMatches(
+	ctx context.Context,
+	fullSystemCombos iter.Seq[data_structures.ComponentKey],
+	ahriIndex *AHRIIndex,
+	opts data_structures.MatchOptions,
+	emit func(data_structures.OutputCSV) error,
+) (int, error) {
+
+	if err := ValidateMatchOptions(opts); err != nil {
+		return 0, err
 	}
 
-	// If there's no second wildcard, we're done
-	if secondWildcardPos == -1 {
-		return []string{string(runes)}
+	reject := func(filter string) {
+		if opts.Rejections != nil {
+			opts.Rejections[filter]++
+		}
 	}
 
-	// Generate all four variations for the second wildcard
-	possibleChars := []rune{'A', 'B', 'C', 'D'}
-	results := make([]string, 0, len(possibleChars))
+	checked := 0
+	for combo := range fullSystemCombos {
+		// Checking every combination would dominate a cheap loop, so poll periodically
+		if checked%cancelCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return checked, err
+			}
+		}
+		checked++
 
-	for _, char := range possibleChars {
-		variation := make([]rune, len(runes))
-		copy(variation, runes)
-		variation[secondWildcardPos] = char
-		results = append(results, string(variation))
+		for _, output := range matchCombo(combo, ahriIndex, opts, reject) {
+			if err := emit(output); err != nil {
+				return checked, err
+			}
+		}
 	}
 
-	return results
+	// The generator ends early rather than failing when ctx is cancelled
+	if err := ctx.Err(); err != nil {
+		return checked, err
+	}
+
+	return checked, nil
 }
 
-func BuildAHRIMap(ahriList []data_structures.AHRIRecord) map[string]string {
-	ahriMap := make(map[string]string)
+// matchCombo applies the filters for the combination's system type and returns its
+// output rows, or nothing if it was rejected
+func matchCombo(
+	combo data_structures.ComponentKey,
+	ahriIndex *AHRIIndex,
+	opts data_structures.MatchOptions,
+	reject func(filter string),
+) []data_structures.OutputCSV {
+	system, known := systemTypeByValue(combo.SystemType)
+	if !known {
+		return nil
+	}
 
-	for _, record := range ahriList {
-		// Expand each component based on its type
-		furnace := NormalizeString(record.Furnace)
-		indoorUnit := NormalizeString(record.IndoorUnit)
-		outdoorUnit := NormalizeString(record.OutdoorUnit)
-		furnaceVariations := expandFurnaceWildcard(furnace.NormalizedModelNumber)
+	if !isAllowedOrientation(combo, opts.AllowedOrientations) {
+		reject(RejectOrientation)
+		return nil
+	}
 
-		indoorVariations := expandIndoorUnitWildcard(indoorUnit.NormalizedModelNumber)
+	for _, filter := range system.Filters {
+		if !filter.Check(combo) {
+			reject(filter.Name)
+			return nil
+		}
+	}
 
-		// Outdoor units don't have wildcards
-		outdoorVariations := []string{outdoorUnit.NormalizedModelNumber}
+	// Handle system types that don't need (or, unless verifying, don't get) AHRI certification
+	verify := system.UncertifiedValue == "" || verifiesSystemType(opts, system)
+	if !system.Certified || !verify {
+		return []data_structures.OutputCSV{heuristicOutput(system, combo)}
+	}
 
-		// Create map entries for all combinations
-		for _, furnace := range furnaceVariations {
-			for _, indoor := range indoorVariations {
-				for _, outdoor := range outdoorVariations {
-					key := outdoor + "|" + indoor + "|" + furnace
-					ahriMap[key] = record.AHRINumber
-				}
-			}
+	// Lookup AHRI certification
+	records, isCertified := system.lookup(combo, ahriIndex)
+	if !isCertified {
+		if system.UncertifiedValue != "" {
+			// Kept apart from certified systems so it can't be mistaken for one
+			output := heuristicOutput(system, combo)
+			output.TypeOfSystem = system.UncertifiedValue
+			output.MatchMethod = MatchTonnageCompatible
+			return []data_structures.OutputCSV{output}
 		}
+		reject(RejectNotCertified)
+		return nil
 	}
 
-	return ahriMap
-}
+	records = orderAHRIRecords(records, opts.PrimaryAHRI)
 
-func FindAHRICertification(config data_structures.ComponentKey, ahriMap map[string]string) (string, bool) {
-	// Build the lookup key from normalized model numbers
-	key := config.OutdoorUnit.NormalizedModelNumber + "|" +
-		config.IndoorUnit.NormalizedModelNumber + "|" +
-		config.Furnace.NormalizedModelNumber
-
-	// Look it up in the map
-	ahriNumber, certified := ahriMap[key]
-	return ahriNumber, certified
+	return certifiedOutputs(combo, records, ahriIndex, opts)
 }
 
-func FindCertifiedMatches(
-	fullSystemCombos []data_structures.ComponentKey,
-	ahriMap map[string]string,
-) ([]data_structures.OutputCSV, error) {
-
-	certifiedMatches := make([]data_structures.OutputCSV, 0)
+// heuristicOutput reports a combination that passed its filters without an AHRI record
+func heuristicOutput(system *SystemType, combo data_structures.ComponentKey) data_structures.OutputCSV {
+	output := data_structures.OutputCSV{
+		Brand:        combo.Brand,
+		Orientation:  systemOrientation(combo),
+		TypeOfSystem: combo.SystemType,
+		Category:     systemCategory(combo),
+	}
+	system.fillOutput(&output, combo)
+	setHeuristicProvenance(&output)
+	return withComponentBrands(output, combo)
+}
 
-	for _, combo := range fullSystemCombos {
-		// Handle system types that don't need AHRI certification
-		if combo.SystemType == systemTypes["furnace"] {
-			output := data_structures.OutputCSV{
-				Brand:        combo.Brand,
-				Orientation:  "",
-				Furnace:      combo.Furnace.InputModelNumber,
-				TypeOfSystem: combo.SystemType,
-			}
-			certifiedMatches = append(certifiedMatches, output)
-			continue
+// certifiedOutputs reports a certified combination as the AHRI output mode directs
+func certifiedOutputs(
+	combo data_structures.ComponentKey,
+	records []data_structures.AHRIRecord,
+	ahriIndex *AHRIIndex,
+	opts data_structures.MatchOptions,
+) []data_structures.OutputCSV {
+	switch opts.AHRIOutput {
+	case AHRIOutputCombined:
+		output := createAHRIOutput(combo, records[0], ahriIndex)
+		others := make([]string, 0, len(records)-1)
+		for _, record := range records[1:] {
+			others = append(others, record.AHRINumber)
 		}
+		output.OtherAHRINumbers = strings.Join(others, "; ")
+		return []data_structures.OutputCSV{output}
 
-		if combo.SystemType == systemTypes["central ac"] {
-			// Apply filters for central ac systems
-			if !isValidIndoorUnit(combo.IndoorUnit) {
-				continue
-			}
-			if !isValidTonnageMatch(combo.OutdoorUnit, combo.IndoorUnit) {
-				continue
-			}
+	case AHRIOutputPrimary:
+		return []data_structures.OutputCSV{createAHRIOutput(combo, records[0], ahriIndex)}
+	}
 
-			output := data_structures.OutputCSV{
-				Brand:          combo.Brand,
-				Orientation:    "",
-				OutdoorUnit:    combo.OutdoorUnit.InputModelNumber,
-				EvaporatorCoil: combo.IndoorUnit.InputModelNumber,
-				TypeOfSystem:   combo.SystemType,
-			}
-			certifiedMatches = append(certifiedMatches, output)
-			continue
+	outputs := make([]data_structures.OutputCSV, 0, len(records))
+	for _, record := range records {
+		outputs = append(outputs, createAHRIOutput(combo, record, ahriIndex))
+	}
+	return outputs
+}
+
+// ValidateMatchOptions checks the AHRI output, primary reference and central ac policies.
+func ValidateMatchOptions(opts data_structures.MatchOptions) error {
+	for _, name := range opts.VerifySystemTypes {
+		sysType, known := findSystemType(name)
+		if !known {
+			return fmt.Errorf("unknown system type to verify: %s", name)
+		}
+		if sysType.UncertifiedValue == "" {
+			return fmt.Errorf("%s systems are always verified", sysType.Name)
 		}
+	}
 
-		// For all other system types, apply standard filters and AHRI lookup
+	switch opts.AHRIOutput {
+	case "", AHRIOutputRows, AHRIOutputCombined, AHRIOutputPrimary:
+	default:
+		return fmt.Errorf("unknown ahri output mode: %s", opts.AHRIOutput)
+	}
 
-		// Filter out horizontal coils
-		if !isValidIndoorUnit(combo.IndoorUnit) {
-			continue
-		}
+	switch opts.PrimaryAHRI {
+	case "", PrimaryAHRIFirst, PrimaryAHRILowestNumber, PrimaryAHRIHighestRating:
+	default:
+		return fmt.Errorf("unknown primary ahri policy: %s", opts.PrimaryAHRI)
+	}
 
-		// Filter tonnage and cabinet mismatches for systems with coils and furnaces
-		if needsCabinetValidation(combo.SystemType) {
-			if !isValidCabinetAndTonnage(combo) {
-				continue
-			}
+	for _, pairing := range opts.CategoryPairings {
+		if pairing.Outdoor == "" || pairing.Indoor == "" {
+			return fmt.Errorf("category pairings need an outdoor and an indoor category")
 		}
+	}
 
-		// Lookup AHRI certification
-		ahriNumber, isCertified := FindAHRICertification(combo, ahriMap)
-		if !isCertified {
-			continue
+	return nil
+}
+
+// verifiesSystemType reports whether the options ask for a system type's optional
+// AHRI lookup (see SystemType.UncertifiedValue)
+func verifiesSystemType(opts data_structures.MatchOptions, system *SystemType) bool {
+	for _, name := range opts.VerifySystemTypes {
+		if sysType, known := findSystemType(name); known && sysType.Value == system.Value {
+			return true
 		}
+	}
+	return false
+}
 
-		output := createAHRIOutput(combo, ahriNumber)
-		certifiedMatches = append(certifiedMatches, output)
+// orderAHRIRecords returns the records with the primary reference first
+func orderAHRIRecords(records []data_structures.AHRIRecord, policy string) []data_structures.AHRIRecord {
+	ordered := make([]data_structures.AHRIRecord, len(records))
+	copy(ordered, records)
+
+	switch policy {
+	case PrimaryAHRILowestNumber:
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return compareAHRINumbers(ordered[i].AHRINumber, ordered[j].AHRINumber) < 0
+		})
+	case PrimaryAHRIHighestRating:
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return compareRatings(ordered[i].Ratings.SEER2, ordered[j].Ratings.SEER2) < 0
+		})
 	}
 
-	return certifiedMatches, nil
+	return ordered
 }
 
 // Helper functions for safer validation
@@ -428,42 +572,57 @@ func isValidCabinetAndTonnage(combo data_structures.ComponentKey) bool {
 	return true
 }
 
-func needsCabinetValidation(systemType string) bool {
-	return systemType == systemTypes["central ac & furnace"] ||
-		systemType == systemTypes["heat pump & furnace"]
+// systemCategory reports the category a combination was generated under: its outdoor
+// unit's, whichever category the pairing matrix let the indoor unit have, or for
+// systems without one the indoor unit's and then the furnace's.
+func systemCategory(combo data_structures.ComponentKey) string {
+	for _, equip := range []data_structures.Equipment{combo.OutdoorUnit, combo.IndoorUnit, combo.Furnace} {
+		if equip.Category != "" {
+			return equip.Category
+		}
+	}
+	return ""
 }
 
-func createAHRIOutput(combo data_structures.ComponentKey, ahriNumber string) data_structures.OutputCSV {
+func createAHRIOutput(combo data_structures.ComponentKey, record data_structures.AHRIRecord, ahriIndex *AHRIIndex) data_structures.OutputCSV {
 	output := data_structures.OutputCSV{
-		AHRINumber:   ahriNumber,
+		RevisionTolerance: strings.Join(ahriIndex.revisionTolerance(combo, record), ", "),
+		SupersededModels:  strings.Join(ahriIndex.supersededComponents(combo, record), "; "),
+
+		AHRINumber:   record.AHRINumber,
 		Brand:        combo.Brand,
-		Orientation:  "",
+		Orientation:  systemOrientation(combo),
 		TypeOfSystem: combo.SystemType,
+		Category:     systemCategory(combo),
+
+		CoolingCapacity:   record.Ratings.CoolingCapacity,
+		SEER2:             record.Ratings.SEER2,
+		EER2:              record.Ratings.EER2,
+		HSPF2:             record.Ratings.HSPF2,
+		HeatingCapacity47: record.Ratings.HeatingCapacity47,
+		HeatingCapacity17: record.Ratings.HeatingCapacity17,
+
+		EERGroundLoop:  record.Ratings.EERGroundLoop,
+		COPGroundLoop:  record.Ratings.COPGroundLoop,
+		EERGroundWater: record.Ratings.EERGroundWater,
+		COPGroundWater: record.Ratings.COPGroundWater,
+		EERWaterLoop:   record.Ratings.EERWaterLoop,
+		COPWaterLoop:   record.Ratings.COPWaterLoop,
 	}
 
-	switch combo.SystemType {
-	case systemTypes["central ac & air handler"]:
-		output.OutdoorUnit = combo.OutdoorUnit.InputModelNumber
-		output.AirHandler = combo.IndoorUnit.InputModelNumber
-
-	case systemTypes["central ac & furnace"]:
-		output.OutdoorUnit = combo.OutdoorUnit.InputModelNumber
-		output.Furnace = combo.Furnace.InputModelNumber
-		output.EvaporatorCoil = combo.IndoorUnit.InputModelNumber
-
-	case systemTypes["heat pump & air handler"]:
-		output.OutdoorUnit = combo.OutdoorUnit.InputModelNumber
-		output.AirHandler = combo.IndoorUnit.InputModelNumber
+	if system, known := systemTypeByValue(combo.SystemType); known {
+		system.fillOutput(&output, combo)
+	}
 
-	case systemTypes["heat pump & furnace"]:
-		output.OutdoorUnit = combo.OutdoorUnit.InputModelNumber
-		output.Furnace = combo.Furnace.InputModelNumber
-		output.EvaporatorCoil = combo.IndoorUnit.InputModelNumber
+	ahriIndex.setProvenance(&output, combo, record)
 
-	case systemTypes["heat pump"]:
-		output.OutdoorUnit = combo.OutdoorUnit.InputModelNumber
-		output.EvaporatorCoil = combo.IndoorUnit.InputModelNumber
-	}
+	return withComponentBrands(output, combo)
+}
 
+// withComponentBrands records the brand of each component present in the combination
+func withComponentBrands(output data_structures.OutputCSV, combo data_structures.ComponentKey) data_structures.OutputCSV {
+	output.OutdoorUnitBrand = combo.OutdoorUnit.Brand
+	output.IndoorUnitBrand = indoorUnits(combo)[0].Brand
+	output.FurnaceBrand = combo.Furnace.Brand
 	return output
 }
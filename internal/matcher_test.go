@@ -0,0 +1,252 @@
+package internal
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+func TestFindCertifiedMatchesAHRIOutput(t *testing.T) {
+	record := func(number, seer2 string) data_structures.AHRIRecord {
+		return data_structures.AHRIRecord{
+			AHRINumber:  number,
+			OutdoorUnit: data_structures.Equipment{InputModelNumber: "GSZB403010"},
+			IndoorUnit:  data_structures.Equipment{InputModelNumber: "AMST36BU1300"},
+			Ratings:     data_structures.AHRIRatings{SEER2: seer2},
+		}
+	}
+	index := testAHRIIndex(t, []data_structures.AHRIRecord{
+		record("1003", "15.2"),
+		record("1001", "14.3"),
+		record("1002", "16.0"),
+	}, 0)
+
+	outdoor := NormalizeString(data_structures.Equipment{Brand: "Goodman", Type: "outdoor unit (hp)", InputModelNumber: "GSZB403010"})
+	indoor := NormalizeString(data_structures.Equipment{Brand: "Goodman", Type: "air handler", InputModelNumber: "AMST36BU1300"})
+	combos := []data_structures.ComponentKey{{
+		Brand:       "Goodman",
+		OutdoorUnit: outdoor,
+		IndoorUnit:  indoor,
+		SystemType:  systemTypeValue("heat pump & air handler"),
+	}}
+
+	tests := []struct {
+		name       string
+		output     string
+		primary    string
+		want       []string // AHRI numbers, one per row
+		wantOthers string
+	}{
+		{name: "rows by default", want: []string{"1003", "1001", "1002"}},
+		{name: "rows lowest number first", output: AHRIOutputRows, primary: PrimaryAHRILowestNumber, want: []string{"1001", "1002", "1003"}},
+		{name: "combined", output: AHRIOutputCombined, want: []string{"1003"}, wantOthers: "1001; 1002"},
+		{name: "combined highest rating", output: AHRIOutputCombined, primary: PrimaryAHRIHighestRating, want: []string{"1002"}, wantOthers: "1003; 1001"},
+		{name: "primary only", output: AHRIOutputPrimary, primary: PrimaryAHRILowestNumber, want: []string{"1001"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := FindCertifiedMatches(context.Background(), slices.Values(combos), index, data_structures.MatchOptions{AHRIOutput: tt.output, PrimaryAHRI: tt.primary})
+			if err != nil {
+				t.Fatalf("FindCertifiedMatches: %v", err)
+			}
+
+			got := []string{}
+			for _, match := range matches {
+				got = append(got, match.AHRINumber)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("AHRI numbers = %v, want %v", got, tt.want)
+			}
+			if matches[0].OtherAHRINumbers != tt.wantOthers {
+				t.Errorf("OtherAHRINumbers = %q, want %q", matches[0].OtherAHRINumbers, tt.wantOthers)
+			}
+		})
+	}
+}
+
+func TestValidateMatchOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    data_structures.MatchOptions
+		wantErr bool
+	}{
+		{name: "defaults"},
+		{name: "known values", opts: data_structures.MatchOptions{AHRIOutput: AHRIOutputCombined, PrimaryAHRI: PrimaryAHRIHighestRating}},
+		{name: "unknown output mode", opts: data_structures.MatchOptions{AHRIOutput: "merged"}, wantErr: true},
+		{name: "unknown primary policy", opts: data_structures.MatchOptions{PrimaryAHRI: "newest"}, wantErr: true},
+		{name: "optional lookup", opts: data_structures.MatchOptions{VerifySystemTypes: []string{"Central AC"}}},
+		{name: "unknown system type to verify", opts: data_structures.MatchOptions{VerifySystemTypes: []string{"boiler"}}, wantErr: true},
+		{name: "system type always verified", opts: data_structures.MatchOptions{VerifySystemTypes: []string{"heat pump"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateMatchOptions(tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHeatPumpCasedCoil(t *testing.T) {
+	equip := func(equipType, model string) data_structures.Equipment {
+		return CategorizeEquipment(NormalizeString(data_structures.Equipment{Brand: "Goodman", Type: equipType, InputModelNumber: model}), DefaultCategoryRules())
+	}
+	list := []data_structures.Equipment{
+		equip("outdoor unit (hp)", "GSZB403010"),
+		equip("outdoor unit (hp)", "GSZV903010"), // communicating
+		equip("evaporator coil", "CAPTA3026B4"),
+		equip("evaporator coil", "CAPTA3626B4"), // 3 ton coil
+		equip("air handler", "AMST36BU1300"),
+		equip("furnace", "GR9S800803BN"),
+	}
+
+	combos, err := GenerateFullSystemEquipmentConfig(context.Background(), list, "heat pump", nil)
+	if err != nil {
+		t.Fatalf("GenerateFullSystemEquipmentConfig: %v", err)
+	}
+	// Only standard heat pumps with standard coils; no air handlers or furnaces
+	got := []string{}
+	for _, combo := range combos {
+		if combo.SystemType != "air_source_heat_pump" || combo.Furnace.InputModelNumber != "" {
+			t.Errorf("unexpected combo %+v", combo)
+		}
+		got = append(got, combo.OutdoorUnit.InputModelNumber+" + "+combo.IndoorUnit.InputModelNumber)
+	}
+	want := []string{"GSZB403010 + CAPTA3026B4", "GSZB403010 + CAPTA3626B4"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("combos = %v, want %v", got, want)
+	}
+
+	// Both are AHRI listed, but the 3 ton coil fails the tonnage check
+	index := testAHRIIndex(t, []data_structures.AHRIRecord{
+		{AHRINumber: "1001", OutdoorUnit: equip("outdoor unit (hp)", "GSZB403010"), IndoorUnit: equip("evaporator coil", "CAPTA3026B4")},
+		{AHRINumber: "1002", OutdoorUnit: equip("outdoor unit (hp)", "GSZB403010"), IndoorUnit: equip("evaporator coil", "CAPTA3626B4")},
+	}, 0)
+	opts := data_structures.MatchOptions{Rejections: make(map[string]int)}
+	matches, err := FindCertifiedMatches(context.Background(), slices.Values(combos), index, opts)
+	if err != nil {
+		t.Fatalf("FindCertifiedMatches: %v", err)
+	}
+	if len(matches) != 1 || matches[0].AHRINumber != "1001" || matches[0].EvaporatorCoil != "CAPTA3026B4" {
+		t.Errorf("matches = %+v, want only 1001", matches)
+	}
+	if opts.Rejections[RejectTonnage] != 1 {
+		t.Errorf("tonnage rejections = %d, want 1", opts.Rejections[RejectTonnage])
+	}
+}
+
+func TestVerifySystemTypes(t *testing.T) {
+	equip := func(equipType, model string) data_structures.Equipment {
+		return CategorizeEquipment(NormalizeString(data_structures.Equipment{Brand: "Goodman", Type: equipType, InputModelNumber: model}), DefaultCategoryRules())
+	}
+	list := []data_structures.Equipment{
+		equip("outdoor unit (ac)", "GSXN403010"),
+		equip("evaporator coil", "CAPTA3026B4"),
+		equip("evaporator coil", "CAPTA3026C4"),
+		equip("evaporator coil", "CAPTA3026D4"),
+	}
+	// The pairs are only listed with furnaces, the usual way condensers and coils are rated
+	index := testAHRIIndex(t, []data_structures.AHRIRecord{
+		{AHRINumber: "1001", OutdoorUnit: list[0], IndoorUnit: list[1], Furnace: equip("furnace", "GR9S800803BN")},
+		{AHRINumber: "1002", OutdoorUnit: list[0], IndoorUnit: equip("evaporator coil", "CAPTA3026C*"), Furnace: equip("furnace", "GD9S800803BN")},
+	}, 0)
+
+	combos, err := GenerateFullSystemEquipmentConfig(context.Background(), list, "central ac", nil)
+	if err != nil {
+		t.Fatalf("GenerateFullSystemEquipmentConfig: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		verify []string
+		want   []string // coil, AHRI number, system type, match method
+	}{
+		{
+			name: "heuristic",
+			want: []string{
+				"CAPTA3026B4  central_ac heuristic",
+				"CAPTA3026C4  central_ac heuristic",
+				"CAPTA3026D4  central_ac heuristic",
+			},
+		},
+		{
+			name:   "verified",
+			verify: []string{"central ac"},
+			want: []string{
+				"CAPTA3026B4 1001 central_ac any furnace",
+				"CAPTA3026C4 1002 central_ac wildcard, any furnace",
+				"CAPTA3026D4  central_ac_tonnage_compatible_not_certified " + MatchTonnageCompatible,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := FindCertifiedMatches(context.Background(), slices.Values(combos), index, data_structures.MatchOptions{VerifySystemTypes: tt.verify})
+			if err != nil {
+				t.Fatalf("FindCertifiedMatches: %v", err)
+			}
+
+			got := []string{}
+			for _, m := range matches {
+				got = append(got, m.EvaporatorCoil+" "+m.AHRINumber+" "+m.TypeOfSystem+" "+m.MatchMethod)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("matches = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateSystemEquipmentConfigSeq(t *testing.T) {
+	list := engineTestEquipment()
+
+	for _, sysType := range engineTestSystemTypes {
+		t.Run(sysType, func(t *testing.T) {
+			want, err := GenerateFullSystemEquipmentConfig(context.Background(), list, sysType, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seq, err := GenerateSystemEquipmentConfigSeq(context.Background(), list, sysType, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := slices.Collect(seq); !reflect.DeepEqual(got, want) {
+				t.Errorf("streamed %d combinations, want the same %d as the slice form", len(got), len(want))
+			}
+
+			// Stopping early ends the sequence
+			taken := 0
+			for range seq {
+				taken++
+				if taken == 2 {
+					break
+				}
+			}
+			if taken != min(2, len(want)) {
+				t.Errorf("took %d combinations before stopping, want %d", taken, min(2, len(want)))
+			}
+		})
+	}
+
+	if _, err := GenerateSystemEquipmentConfigSeq(context.Background(), []data_structures.Equipment{{Type: "boiler"}}, "furnace", nil); err == nil {
+		t.Error("unknown equipment type was not reported up front")
+	}
+
+	// A cancelled context ends the sequence without producing anything
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	seq, err := GenerateSystemEquipmentConfigSeq(ctx, list, "heat pump & furnace", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slices.Collect(seq); len(got) != 0 {
+		t.Errorf("cancelled sequence produced %d combinations", len(got))
+	}
+}
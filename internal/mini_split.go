@@ -0,0 +1,397 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// MaxIndoorHeads is the most indoor heads a mini-split outdoor unit can feed
+const MaxIndoorHeads = 5
+
+// componentPair is a stocked component and the AHRI rated model it was matched to
+type componentPair struct {
+	name    string
+	stocked data_structures.Equipment
+	rated   data_structures.Equipment
+}
+
+// indoorUnits returns the combination's indoor heads, or its single indoor unit
+func indoorUnits(combo data_structures.ComponentKey) []data_structures.Equipment {
+	if len(combo.IndoorHeads) > 0 {
+		return combo.IndoorHeads
+	}
+	return []data_structures.Equipment{combo.IndoorUnit}
+}
+
+// recordIndoorUnits returns the record's indoor heads, or its single indoor unit
+func recordIndoorUnits(record data_structures.AHRIRecord) []data_structures.Equipment {
+	if len(record.IndoorHeads) > 0 {
+		return record.IndoorHeads
+	}
+	return []data_structures.Equipment{record.IndoorUnit}
+}
+
+/*
+headsKey joins the model keys of a set of (normalized) indoor heads into the indoor
+part of an AHRI key. AHRI lists a multi-split system's heads in no particular order,
+so the keys are sorted, which lines up exact models. Sorting can't line up wildcard
+models with the models they stand for, so those are matched as a set (see comboKeys).
+A single head's key is just its model key, so single zone systems match ordinary
+AHRI records.
+*/
+func (index *AHRIIndex) headsKey(heads []data_structures.Equipment) string {
+	keys := make([]string, len(heads))
+	for i, head := range heads {
+		keys[i] = index.modelKey(ComponentIndoorUnit, head)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, string(headSeparator))
+}
+
+// indoorKey is the indoor part of the combination's AHRI key
+func (index *AHRIIndex) indoorKey(combo data_structures.ComponentKey) string {
+	if len(combo.IndoorHeads) > 0 {
+		return index.headsKey(combo.IndoorHeads)
+	}
+	return index.modelKey(ComponentIndoorUnit, combo.IndoorUnit)
+}
+
+// comboKey is the combination's AHRI key, built from its model keys (see modelKey)
+func (index *AHRIIndex) comboKey(combo data_structures.ComponentKey) string {
+	return ahriKey(index.modelKey(ComponentOutdoorUnit, combo.OutdoorUnit),
+		index.indoorKey(combo),
+		index.modelKey(ComponentFurnace, combo.Furnace))
+}
+
+/*
+comboKeys returns the keys to look the combination up under: comboKey, then, when
+the index has multi-split records with wildcard heads, the key for every other order
+of the combination's heads. A wildcard record's heads can sort differently from the
+heads they certify (A*C sorts before AAB, ABC after it), so a set of heads is
+certified when any order of it matches the record head by head.
+*/
+func (index *AHRIIndex) comboKeys(combo data_structures.ComponentKey) []string {
+	keys := []string{index.comboKey(combo)}
+	if !index.wildcardHeads || len(combo.IndoorHeads) < 2 {
+		return keys
+	}
+
+	outdoor := index.modelKey(ComponentOutdoorUnit, combo.OutdoorUnit)
+	furnace := index.modelKey(ComponentFurnace, combo.Furnace)
+
+	heads := make([]string, len(combo.IndoorHeads))
+	for i, head := range combo.IndoorHeads {
+		heads[i] = index.modelKey(ComponentIndoorUnit, head)
+	}
+	sort.Strings(heads)
+	for nextPermutation(heads) {
+		keys = append(keys, ahriKey(outdoor, strings.Join(heads, string(headSeparator)), furnace))
+	}
+
+	return keys
+}
+
+// nextPermutation rearranges keys into the next lexicographic order, returning false
+// once they are back in sorted order. Repeated keys give each distinct order once.
+func nextPermutation(keys []string) bool {
+	i := len(keys) - 2
+	for i >= 0 && keys[i] >= keys[i+1] {
+		i--
+	}
+	if i < 0 {
+		slices.Reverse(keys)
+		return false
+	}
+	j := len(keys) - 1
+	for keys[j] <= keys[i] {
+		j--
+	}
+	keys[i], keys[j] = keys[j], keys[i]
+	slices.Reverse(keys[i+1:])
+	return true
+}
+
+/*
+assignHeads orders the stocked heads to line up with the rated heads, so each
+stocked head sits opposite a rated model that matches it, wildcards included.
+Heads that can't all be lined up (e.g. certified through superseded models) are
+left in sorted order.
+*/
+func (index *AHRIIndex) assignHeads(stocked []data_structures.Equipment, rated []data_structures.Equipment) []data_structures.Equipment {
+	if len(stocked) != len(rated) {
+		return stocked
+	}
+
+	assigned := make([]data_structures.Equipment, len(rated))
+	used := make([]bool, len(stocked))
+	var assign func(r int) bool
+	assign = func(r int) bool {
+		if r == len(rated) {
+			return true
+		}
+		pattern := index.modelKey(ComponentIndoorUnit, NormalizeString(rated[r]))
+		for s := range stocked {
+			if used[s] || !index.matchModel(pattern, index.modelKey(ComponentIndoorUnit, stocked[s])) {
+				continue
+			}
+			used[s] = true
+			assigned[r] = stocked[s]
+			if assign(r + 1) {
+				return true
+			}
+			used[s] = false
+		}
+		return false
+	}
+
+	if !assign(0) {
+		return stocked
+	}
+	return assigned
+}
+
+// componentPairs lines each component of combo up with the model record rates it
+// under. Indoor heads are paired with the rated heads they match (see assignHeads).
+func (index *AHRIIndex) componentPairs(combo data_structures.ComponentKey, record data_structures.AHRIRecord) []componentPair {
+	pairs := []componentPair{{ComponentOutdoorUnit, combo.OutdoorUnit, record.OutdoorUnit}}
+
+	stocked := slices.Clone(indoorUnits(combo))
+	rated := slices.Clone(recordIndoorUnits(record))
+	if len(combo.IndoorHeads) > 0 {
+		sort.SliceStable(stocked, func(i, j int) bool {
+			return index.modelKey(ComponentIndoorUnit, stocked[i]) < index.modelKey(ComponentIndoorUnit, stocked[j])
+		})
+		sort.SliceStable(rated, func(i, j int) bool {
+			return index.modelKey(ComponentIndoorUnit, NormalizeString(rated[i])) <
+				index.modelKey(ComponentIndoorUnit, NormalizeString(rated[j]))
+		})
+		stocked = index.assignHeads(stocked, rated)
+	}
+	for i := range stocked {
+		pair := componentPair{name: ComponentIndoorUnit, stocked: stocked[i]}
+		if i < len(rated) {
+			pair.rated = rated[i]
+		}
+		pairs = append(pairs, pair)
+	}
+
+	return append(pairs, componentPair{ComponentFurnace, combo.Furnace, record.Furnace})
+}
+
+// ahriHeadColumn returns the head number (2 to MaxIndoorHeads) of an AHRI export
+// column listing a further indoor head of a multi-split system, e.g. "Indoor Unit 2",
+// or 0 for any other column
+func ahriHeadColumn(header string) int {
+	h := strings.ToLower(strings.TrimSpace(header))
+
+	for _, prefix := range []string{"indoor unit ", "indoor head "} {
+		if !strings.HasPrefix(h, prefix) {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(h, prefix)))
+		if err == nil && n >= 2 && n <= MaxIndoorHeads {
+			return n
+		}
+	}
+	return 0
+}
+
+// IndoorHeadCount returns the most indoor heads any registered system type can have
+func IndoorHeadCount() int {
+	count := 0
+	for _, sysType := range systemTypeRegistry {
+		count = max(count, sysType.MaxHeads)
+	}
+	return count
+}
+
+/*
+forEachHeadSet calls fn with every set of minHeads to maxHeads indoor heads drawn
+from pool, smallest sets first. A head may appear more than once in a set (two
+identical wall units), and each set lists its heads in pool order, so every
+combination of heads is produced exactly once. It returns false once fn does.
+*/
+func forEachHeadSet(pool []data_structures.Equipment, minHeads int, maxHeads int, fn func([]data_structures.Equipment) bool) bool {
+	picks := make([]int, 0, maxHeads)
+
+	var pick func(from int, size int) bool
+	pick = func(from int, size int) bool {
+		if len(picks) == size {
+			heads := make([]data_structures.Equipment, size)
+			for i, p := range picks {
+				heads[i] = pool[p]
+			}
+			return fn(heads)
+		}
+		for p := from; p < len(pool); p++ {
+			picks = append(picks, p)
+			more := pick(p, size)
+			picks = picks[:len(picks)-1]
+			if !more {
+				return false
+			}
+		}
+		return true
+	}
+
+	for size := minHeads; size <= maxHeads; size++ {
+		if !pick(0, size) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+generateCertifiedHeadCombos is GenerateCertifiedSystemEquipmentConfig for mini-split
+system types. Each AHRI key's indoor heads are found in stock one by one, and every
+stocked set of heads they cover becomes a candidate, returned in the order the
+Cartesian generator produces it.
+*/
+func generateCertifiedHeadCombos(
+	ctx context.Context,
+	list []data_structures.Equipment,
+	system *SystemType,
+	ahriIndex *AHRIIndex,
+	stock *jobStock,
+	pairings []data_structures.CategoryPairing,
+) ([]data_structures.ComponentKey, error) {
+	categories := EquipmentCategories(list)
+	categoryIdx := func(pos int) int {
+		return slices.Index(categories, list[pos].Category)
+	}
+	pairs := categoryPairing(pairings)
+
+	// A head's place in the Cartesian generator's pool: by role, then category, then list position
+	headRank := func(pos int) [3]int {
+		role, _ := equipmentRole(list[pos].Type)
+		return [3]int{slices.Index(system.Heads, role), categoryIdx(pos), pos}
+	}
+	compareHeads := func(a, b int) int {
+		x, y := headRank(a), headRank(b)
+		for k := range x {
+			if x[k] != y[k] {
+				return x[k] - y[k]
+			}
+		}
+		return 0
+	}
+
+	type candidate struct {
+		outdoor int
+		heads   []int
+	}
+	seen := make(map[string]bool)
+	candidates := []candidate{}
+
+	var ctxErr error
+	keysChecked := 0
+
+	ahriIndex.forEachKey(system.Value, func(manufacturer string, key string) {
+		if ctxErr != nil {
+			return
+		}
+		if keysChecked%cancelCheckInterval == 0 {
+			if ctxErr = ctx.Err(); ctxErr != nil {
+				return
+			}
+		}
+		keysChecked++
+
+		models := strings.Split(key, string(keySeparator))
+		if len(models) != 3 || !ahriIndex.matchModel(models[2], "") {
+			return
+		}
+		patterns := strings.Split(models[1], string(headSeparator))
+		if len(patterns) < system.MinHeads || len(patterns) > system.MaxHeads {
+			return
+		}
+
+		outdoors := stock.find(system.Outdoor, models[0])
+		if len(outdoors) == 0 {
+			return
+		}
+		options := make([][]int, len(patterns))
+		for i, pattern := range patterns {
+			for _, role := range system.Heads {
+				options[i] = append(options[i], stock.find(role, pattern)...)
+			}
+			if len(options[i]) == 0 {
+				return
+			}
+		}
+
+		for _, o := range outdoors {
+			// Manufacturer specific records only certify that brand's combinations
+			if !ahriIndex.certifiesBrand(manufacturer, qualifierValue(list[o].Brand)) {
+				continue
+			}
+
+			// Every pick of one stocked head per certified head
+			heads := make([]int, len(patterns))
+			var pick func(i int)
+			pick = func(i int) {
+				if i < len(patterns) {
+					for _, h := range options[i] {
+						// Heads pair with outdoor units of compatible categories
+						if !pairs(list[o].Category, list[h].Category) {
+							continue
+						}
+						heads[i] = h
+						pick(i + 1)
+					}
+					return
+				}
+
+				sorted := slices.Clone(heads)
+				slices.SortFunc(sorted, compareHeads)
+				id := fmt.Sprint(o, sorted)
+				if !seen[id] {
+					seen[id] = true
+					candidates = append(candidates, candidate{outdoor: o, heads: sorted})
+				}
+			}
+			pick(0)
+		}
+	})
+
+	if ctxErr != nil {
+		return nil, ctxErr
+	}
+
+	// Match the Cartesian generator's order: category, outdoor, number of heads, heads
+	sort.Slice(candidates, func(a, b int) bool {
+		ca, cb := candidates[a], candidates[b]
+		if x, y := categoryIdx(ca.outdoor), categoryIdx(cb.outdoor); x != y {
+			return x < y
+		}
+		if ca.outdoor != cb.outdoor {
+			return ca.outdoor < cb.outdoor
+		}
+		if len(ca.heads) != len(cb.heads) {
+			return len(ca.heads) < len(cb.heads)
+		}
+		return slices.CompareFunc(ca.heads, cb.heads, compareHeads) < 0
+	})
+
+	equipConfigs := make([]data_structures.ComponentKey, 0, len(candidates))
+	for _, c := range candidates {
+		combo := data_structures.ComponentKey{
+			Brand:       list[c.outdoor].Brand,
+			OutdoorUnit: list[c.outdoor],
+			SystemType:  system.Value,
+		}
+		for _, h := range c.heads {
+			combo.IndoorHeads = append(combo.IndoorHeads, list[h])
+		}
+		equipConfigs = append(equipConfigs, combo)
+	}
+
+	return equipConfigs, nil
+}
@@ -0,0 +1,241 @@
+package internal
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// miniSplitTestEquipment is a mini-split catalog: two outdoor units and a head of each type
+func miniSplitTestEquipment() []data_structures.Equipment {
+	stocked := []struct{ equipType, model string }{
+		{data_structures.TypeMiniSplit, "MXO18"},
+		{data_structures.TypeMiniSplit, "MXO36"},
+		{data_structures.TypeDuctlessWall, "MXW09"},
+		{data_structures.TypeDuctlessWall, "MXW12"},
+		{data_structures.TypeCeilingCassette, "MXC12"},
+		{data_structures.TypeDuctedSlim, "MXS18"},
+	}
+
+	list := make([]data_structures.Equipment, 0, len(stocked))
+	for _, s := range stocked {
+		item := NormalizeString(data_structures.Equipment{InputModelNumber: s.model, Brand: "Goodman", Type: s.equipType})
+		list = append(list, CategorizeEquipment(item, DefaultCategoryRules()))
+	}
+	return list
+}
+
+// miniSplitTestRecords certifies single zone and multi-zone systems, one of them
+// with a wildcard head that sorts apart from the head it certifies
+func miniSplitTestRecords() []data_structures.AHRIRecord {
+	record := func(number, outdoor string, heads ...string) data_structures.AHRIRecord {
+		r := data_structures.AHRIRecord{
+			AHRINumber:  number,
+			OutdoorUnit: data_structures.Equipment{InputModelNumber: outdoor},
+			IndoorUnit:  data_structures.Equipment{InputModelNumber: heads[0]},
+		}
+		if len(heads) > 1 {
+			for _, head := range heads {
+				r.IndoorHeads = append(r.IndoorHeads, data_structures.Equipment{InputModelNumber: head})
+			}
+		}
+		return r
+	}
+
+	return []data_structures.AHRIRecord{
+		record("2001", "MXO18", "MXW09"),
+		record("2002", "MXO18", "MXS18"),
+		record("2003", "MXO36", "MXW12", "MXW09"),
+		record("2004", "MXO36", "MXC12", "M*W09"),
+		record("2005", "MXO36", "MXW12", "MXW12", "MXS18"),
+	}
+}
+
+// headModels lists the input models of a match's indoor heads
+func headModels(combo data_structures.ComponentKey) []string {
+	models := []string{}
+	for _, head := range combo.IndoorHeads {
+		models = append(models, head.InputModelNumber)
+	}
+	return models
+}
+
+func TestMiniSplitMatches(t *testing.T) {
+	list := miniSplitTestEquipment()
+	ahriIndex := testAHRIIndex(t, miniSplitTestRecords(), 0)
+
+	tests := []struct {
+		sysType string
+		want    map[string]string // AHRI number -> outdoor unit and heads
+	}{
+		{"mini split", map[string]string{
+			"2001": "MXO18 MXW09",
+			"2002": "MXO18 MXS18",
+		}},
+		{"multi-zone mini split", map[string]string{
+			"2003": "MXO36 MXW09 MXW12",
+			"2004": "MXO36 MXW09 MXC12",
+			"2005": "MXO36 MXW12 MXW12 MXS18",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sysType, func(t *testing.T) {
+			cartesian, err := GenerateFullSystemEquipmentConfig(context.Background(), list, tt.sysType, nil)
+			if err != nil {
+				t.Fatalf("cartesian engine: %v", err)
+			}
+			certified, err := GenerateCertifiedSystemEquipmentConfig(context.Background(), list, tt.sysType, ahriIndex, nil, nil)
+			if err != nil {
+				t.Fatalf("certification engine: %v", err)
+			}
+
+			want, err := FindCertifiedMatches(context.Background(), slices.Values(cartesian), ahriIndex, data_structures.MatchOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := FindCertifiedMatches(context.Background(), slices.Values(certified), ahriIndex, data_structures.MatchOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("certification engine matches differ\ngot:  %+v\nwant: %+v", got, want)
+			}
+
+			matched := make(map[string]string)
+			for _, match := range want {
+				models := append([]string{match.OutdoorUnit}, match.IndoorHeads...)
+				matched[match.AHRINumber] = strings.Join(models, " ")
+			}
+			if !reflect.DeepEqual(matched, tt.want) {
+				t.Errorf("matches = %v, want %v", matched, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiniSplitWildcardHeads(t *testing.T) {
+	ahriIndex := testAHRIIndex(t, miniSplitTestRecords(), 0)
+	list := miniSplitTestEquipment()
+	combo := data_structures.ComponentKey{
+		Brand:       "Goodman",
+		OutdoorUnit: list[1],
+		IndoorHeads: []data_structures.Equipment{list[2], list[4]}, // MXW09, MXC12
+		SystemType:  systemTypeValue("multi-zone mini split"),
+	}
+
+	records, certified := FindAHRICertification(combo, ahriIndex)
+	if !certified || len(records) != 1 || records[0].AHRINumber != "2004" {
+		t.Fatalf("FindAHRICertification = %+v, %v, want record 2004", records, certified)
+	}
+
+	// Each stocked head is paired with the rated head it matches, whatever their order
+	pairs := ahriIndex.componentPairs(combo, records[0])
+	got := make(map[string]string)
+	for _, pair := range pairs[1 : len(pairs)-1] {
+		got[pair.stocked.InputModelNumber] = pair.rated.InputModelNumber
+	}
+	if want := map[string]string{"MXW09": "M*W09", "MXC12": "MXC12"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("head pairs = %v, want %v", got, want)
+	}
+
+	output := data_structures.OutputCSV{}
+	ahriIndex.setProvenance(&output, combo, records[0])
+	if output.WildcardPositions != "indoor head 2 2" || output.Confidence != ConfidenceMedium {
+		t.Errorf("provenance = %q, %q, want wildcard at indoor head 2 2, medium confidence", output.WildcardPositions, output.Confidence)
+	}
+}
+
+func TestForEachHeadSet(t *testing.T) {
+	pool := []data_structures.Equipment{{InputModelNumber: "A"}, {InputModelNumber: "B"}}
+
+	got := []string{}
+	forEachHeadSet(pool, 1, 2, func(heads []data_structures.Equipment) bool {
+		got = append(got, headModels(data_structures.ComponentKey{IndoorHeads: heads})...)
+		got = append(got, "|")
+		return true
+	})
+	want := []string{"A", "|", "B", "|", "A", "A", "|", "A", "B", "|", "B", "B", "|"}
+	if !slices.Equal(got, want) {
+		t.Errorf("head sets = %v, want %v", got, want)
+	}
+
+	calls := 0
+	forEachHeadSet(pool, 1, 2, func([]data_structures.Equipment) bool {
+		calls++
+		return false
+	})
+	if calls != 1 {
+		t.Errorf("fn called %d times after returning false, want 1", calls)
+	}
+}
+
+func TestCSVAHRIReaderIndoorHeads(t *testing.T) {
+	content := "AHRI Ref. Number,Outdoor Unit,Indoor Unit,Furnace,Indoor Unit 2,Indoor Unit 3,SEER2\n" +
+		"2001,MXO18,MXW09,,,,20\n" +
+		"2003,MXO36,MXW12,,MXW09,MXC12,19\n"
+
+	records, err := CSVAHRIReader(writeTestCSV(t, content))
+	if err != nil {
+		t.Fatalf("CSVAHRIReader: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	if records[0].IndoorHeads != nil {
+		t.Errorf("single zone record has heads %+v", records[0].IndoorHeads)
+	}
+
+	heads := []string{}
+	for _, head := range records[1].IndoorHeads {
+		heads = append(heads, head.InputModelNumber)
+	}
+	if want := []string{"MXW12", "MXW09", "MXC12"}; !slices.Equal(heads, want) {
+		t.Errorf("heads = %v, want %v", heads, want)
+	}
+	if records[1].Ratings.SEER2 != "19" {
+		t.Errorf("SEER2 = %q, want the rating after the head columns", records[1].Ratings.SEER2)
+	}
+}
+
+func TestAHRIHeadColumn(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int
+	}{
+		{"Indoor Unit 2", 2},
+		{" indoor head 5 ", 5},
+		{"Indoor Unit 1", 0},
+		{"Indoor Unit 6", 0},
+		{"Indoor Unit", 0},
+	}
+
+	for _, tt := range tests {
+		if got := ahriHeadColumn(tt.header); got != tt.want {
+			t.Errorf("ahriHeadColumn(%q) = %d, want %d", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestRoleColumns(t *testing.T) {
+	ducted := engineTestEquipment()
+	if got := RoleColumns(ducted); len(got) != 0 {
+		t.Errorf("RoleColumns without heads = %+v, want none", got)
+	}
+
+	got := RoleColumns(append(ducted, miniSplitTestEquipment()...))
+	if !reflect.DeepEqual(got, IndoorHeadColumns(MaxIndoorHeads)) {
+		t.Errorf("RoleColumns = %+v, want one column per head", got)
+	}
+}
+
+func TestMatrixIndoorLabelHeads(t *testing.T) {
+	match := data_structures.OutputCSV{IndoorHeads: []string{"MXW09", "MXC12"}}
+	if got := matrixIndoorLabel(match); got != "MXW09 + MXC12" {
+		t.Errorf("matrixIndoorLabel = %q, want the heads joined", got)
+	}
+}
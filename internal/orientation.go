@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+/*
+DefaultOrientationRules returns the model position rules used when no rules file is supplied.
+Positions are zero-indexed into the normalized model number:
+  - Coils carry the orientation in position 1 (the same 'H' that isValidIndoorUnit filters on)
+  - Furnaces carry it in position 1 (the 'R'/'D' position expanded by the AHRI furnace wildcard)
+  - Air handlers are multiposition
+*/
+func DefaultOrientationRules() []data_structures.OrientationRule {
+	return []data_structures.OrientationRule{
+		{Type: "coil", Position: 1, Code: "A", Orientation: data_structures.OrientationMultiposition},
+		{Type: "coil", Position: 1, Code: "H", Orientation: data_structures.OrientationHorizontal},
+		{Type: "coil", Position: 1, Code: "U", Orientation: data_structures.OrientationUpflow},
+		{Type: "coil", Position: 1, Code: "D", Orientation: data_structures.OrientationDownflow},
+		{Type: "furnace", Position: 1, Code: "M", Orientation: data_structures.OrientationMultiposition},
+		{Type: "furnace", Position: 1, Code: "C", Orientation: data_structures.OrientationUpflow},
+		{Type: "furnace", Position: 1, Code: "R", Orientation: data_structures.OrientationUpflow},
+		{Type: "furnace", Position: 1, Code: "D", Orientation: data_structures.OrientationDownflow},
+		{Type: "handler", Position: 1, Code: "*", Orientation: data_structures.OrientationMultiposition},
+	}
+}
+
+// orientations lists the orientations a rule may assign
+var orientations = []string{
+	data_structures.OrientationUpflow,
+	data_structures.OrientationDownflow,
+	data_structures.OrientationHorizontal,
+	data_structures.OrientationMultiposition,
+}
+
+/*
+LoadOrientationRules reads orientation rules from a csv file with the columns
+"Type", "Position", "Code" and "Orientation". Type is matched against the equipment
+type the same way the rest of the parser does (e.g. "coil", "furnace", "handler").
+Every rule needs a type and a code, and an orientation from the known set, so a
+mistyped file fails instead of quietly leaving equipment without an orientation.
+*/
+func LoadOrientationRules(filename string) ([]data_structures.OrientationRule, error) {
+	headers, err := GetCSVHeader(filename, []string{"Type", "Position", "Code", "Orientation"})
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error with opening %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+
+	if _, err := r.Read(); err != nil {
+		return nil, fmt.Errorf("error reading header: %w", err)
+	}
+
+	rules := []data_structures.OrientationRule{}
+	line := 1
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV: %w", err)
+		}
+		line++
+
+		field := func(name string) string {
+			idx := headers[name]
+			if idx >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[idx])
+		}
+
+		position, err := strconv.Atoi(field("position"))
+		if err != nil || position < 0 {
+			return nil, fmt.Errorf("line %d: invalid position %q", line, field("position"))
+		}
+
+		rule := data_structures.OrientationRule{
+			Type:        strings.ToLower(field("type")),
+			Position:    position,
+			Code:        strings.ToUpper(field("code")),
+			Orientation: strings.ToLower(field("orientation")),
+		}
+		if rule.Type == "" || rule.Code == "" {
+			return nil, fmt.Errorf("line %d: orientation rules need a type and a code", line)
+		}
+		if !slices.Contains(orientations, rule.Orientation) {
+			return nil, fmt.Errorf("line %d: unknown orientation %q (use %s)", line, field("orientation"), strings.Join(orientations, ", "))
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// AssignOrientation sets the equipment orientation from the first matching rule.
+// Equipment that matches no rule is left with an empty orientation.
+func AssignOrientation(equipment data_structures.Equipment, rules []data_structures.OrientationRule) data_structures.Equipment {
+	typeLower := strings.ToLower(equipment.Type)
+	model := strings.ToUpper(equipment.NormalizedModelNumber)
+
+	for _, rule := range rules {
+		if !strings.Contains(typeLower, rule.Type) {
+			continue
+		}
+		if rule.Position >= len(model) {
+			continue
+		}
+		if rule.Code != "*" && string(model[rule.Position]) != rule.Code {
+			continue
+		}
+		equipment.Orientation = rule.Orientation
+		return equipment
+	}
+
+	equipment.Orientation = ""
+	return equipment
+}
+
+// systemOrientation picks the orientation reported for a combination.
+// The furnace sets how the system is installed, so it wins over the indoor unit.
+func systemOrientation(combo data_structures.ComponentKey) string {
+	if combo.Furnace.Orientation != "" {
+		return combo.Furnace.Orientation
+	}
+	return combo.IndoorUnit.Orientation
+}
+
+func isAllowedOrientation(combo data_structures.ComponentKey, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	for _, equip := range []data_structures.Equipment{combo.IndoorUnit, combo.Furnace} {
+		if equip.Orientation == "" {
+			continue
+		}
+		if !slices.Contains(allowed, equip.Orientation) {
+			return false
+		}
+	}
+	return true
+}
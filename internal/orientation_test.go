@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+func TestAssignOrientation(t *testing.T) {
+	tests := []struct {
+		name      string
+		equipType string
+		model     string
+		want      string
+	}{
+		{name: "multiposition coil", equipType: "evaporator coil", model: "CAPTA3026B4", want: data_structures.OrientationMultiposition},
+		{name: "horizontal coil", equipType: "evaporator coil", model: "CHPTA3026B4", want: data_structures.OrientationHorizontal},
+		{name: "upflow furnace", equipType: "furnace", model: "GR9S800803B", want: data_structures.OrientationUpflow},
+		{name: "downflow furnace", equipType: "furnace", model: "GD9S800803B", want: data_structures.OrientationDownflow},
+		{name: "multiposition furnace", equipType: "furnace", model: "GM9S800803B", want: data_structures.OrientationMultiposition},
+		{name: "air handler", equipType: "air handler", model: "AMST30BU130", want: data_structures.OrientationMultiposition},
+		{name: "unknown code", equipType: "evaporator coil", model: "CXPTA3026B4", want: ""},
+		{name: "outdoor unit", equipType: "outdoor unit (ac)", model: "GSXN403010", want: ""},
+	}
+
+	rules := DefaultOrientationRules()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			equipment := data_structures.Equipment{Type: tt.equipType, NormalizedModelNumber: tt.model, Orientation: "stale"}
+			if got := AssignOrientation(equipment, rules).Orientation; got != tt.want {
+				t.Errorf("orientation = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadOrientationRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []data_structures.OrientationRule
+		wantErr string
+	}{
+		{
+			name:    "valid rules",
+			content: "Type,Position,Code,Orientation\ncoil,1,h,Horizontal\nhandler,0,*,multiposition\n",
+			want: []data_structures.OrientationRule{
+				{Type: "coil", Position: 1, Code: "H", Orientation: data_structures.OrientationHorizontal},
+				{Type: "handler", Position: 0, Code: "*", Orientation: data_structures.OrientationMultiposition},
+			},
+		},
+		{name: "invalid position", content: "Type,Position,Code,Orientation\ncoil,one,H,horizontal\n", wantErr: "line 2: invalid position"},
+		{name: "empty code", content: "Type,Position,Code,Orientation\ncoil,1,H,horizontal\ncoil,1,,upflow\n", wantErr: "line 3: orientation rules need a type and a code"},
+		{name: "empty type", content: "Type,Position,Code,Orientation\n,1,H,horizontal\n", wantErr: "line 2: orientation rules need a type and a code"},
+		{name: "unknown orientation", content: "Type,Position,Code,Orientation\ncoil,1,H,sideways\n", wantErr: `line 2: unknown orientation "sideways"`},
+		{name: "empty orientation", content: "Type,Position,Code,Orientation\ncoil,1,H,\n", wantErr: "line 2: unknown orientation"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "orientation.csv")
+			if err := os.WriteFile(filename, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := LoadOrientationRules(filename)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadOrientationRules error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadOrientationRules: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("rules = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("rule %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSystemOrientation(t *testing.T) {
+	upflowFurnace := data_structures.Equipment{Orientation: data_structures.OrientationUpflow}
+	horizontalCoil := data_structures.Equipment{Orientation: data_structures.OrientationHorizontal}
+	unknown := data_structures.Equipment{}
+
+	tests := []struct {
+		name    string
+		combo   data_structures.ComponentKey
+		allowed []string
+		want    string
+		wantOK  bool
+	}{
+		{name: "furnace wins", combo: data_structures.ComponentKey{Furnace: upflowFurnace, IndoorUnit: horizontalCoil}, want: "upflow", wantOK: true},
+		{name: "indoor unit without a furnace", combo: data_structures.ComponentKey{IndoorUnit: horizontalCoil}, want: "horizontal", wantOK: true},
+		{name: "every component allowed", combo: data_structures.ComponentKey{Furnace: upflowFurnace, IndoorUnit: horizontalCoil}, allowed: []string{"upflow", "horizontal"}, want: "upflow", wantOK: true},
+		{name: "one component not allowed", combo: data_structures.ComponentKey{Furnace: upflowFurnace, IndoorUnit: horizontalCoil}, allowed: []string{"upflow"}, want: "upflow"},
+		{name: "unknown orientation passes", combo: data_structures.ComponentKey{Furnace: upflowFurnace, IndoorUnit: unknown}, allowed: []string{"upflow"}, want: "upflow", wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := systemOrientation(tt.combo); got != tt.want {
+				t.Errorf("systemOrientation = %q, want %q", got, tt.want)
+			}
+			if got := isAllowedOrientation(tt.combo, tt.allowed); got != tt.wantOK {
+				t.Errorf("isAllowedOrientation = %v, want %v", got, tt.wantOK)
+			}
+		})
+	}
+}
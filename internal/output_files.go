@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// IndexFilename is the name of the index written alongside split output files.
+const IndexFilename = "index.csv"
+
+/*
+writeFileAtomic writes filename through a temporary file in the same directory
+followed by a rename, so a crash mid-write never leaves a truncated file behind
+(and a synced drive never picks one up). The file keeps the mode of the one it
+replaces, or is created 0644 like os.Create would leave it.
+*/
+func writeFileAtomic(filename string, write func(w io.Writer) error) error {
+	dir := filepath.Dir(filename)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	tmpName := tmp.Name()
+
+	// Remove the temp file on any failure; after a successful rename this is a no-op
+	defer os.Remove(tmpName)
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	// os.CreateTemp makes the file readable by its owner only
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set output file permissions: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync output file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close output file: %w", err)
+	}
+	if err := os.Rename(tmpName, filename); err != nil {
+		return fmt.Errorf("failed to move output file into place: %w", err)
+	}
+
+	return nil
+}
+
+/*
+WriteSplitOutput writes one file per brand and/or system type into dir, plus an
+index file (IndexFilename) listing every file produced. Each file is written
+atomically. With neither split enabled a single file holding every match is written.
+*/
+func WriteSplitOutput(
+	matches []data_structures.OutputCSV,
+	layout data_structures.OutputLayout,
+	dir string,
+	byBrand bool,
+	bySystemType bool,
+) ([]data_structures.OutputFile, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	ext := ".csv"
+	if layout.Template != "" {
+		ext = ".txt"
+	}
+
+	// Group the matches, keeping their existing order within each group
+	groups := make(map[data_structures.OutputFile][]data_structures.OutputCSV)
+	for _, match := range matches {
+		key := data_structures.OutputFile{}
+		if byBrand {
+			key.Brand = match.Brand
+		}
+		if bySystemType {
+			key.TypeOfSystem = match.TypeOfSystem
+		}
+		groups[key] = append(groups[key], match)
+	}
+
+	keys := make([]data_structures.OutputFile, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Brand != keys[j].Brand {
+			return keys[i].Brand < keys[j].Brand
+		}
+		return keys[i].TypeOfSystem < keys[j].TypeOfSystem
+	})
+
+	files := make([]data_structures.OutputFile, 0, len(groups))
+	used := make(map[string]bool)
+	for _, key := range keys {
+		rows := groups[key]
+		parts := []string{}
+		if byBrand {
+			parts = append(parts, key.Brand)
+		}
+		if bySystemType {
+			parts = append(parts, key.TypeOfSystem)
+		}
+		if len(parts) == 0 {
+			parts = append(parts, "certified_hvac_matches")
+		}
+
+		key.Filename = uniqueFilename(used, sanitizeFilename(strings.Join(parts, "_")), ext)
+		key.Rows = len(rows)
+		files = append(files, key)
+	}
+
+	for _, file := range files {
+		rows := groups[data_structures.OutputFile{Brand: file.Brand, TypeOfSystem: file.TypeOfSystem}]
+		if err := WriteOutputLayout(rows, layout, filepath.Join(dir, file.Filename)); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", file.Filename, err)
+		}
+	}
+
+	if err := writeOutputIndex(files, filepath.Join(dir, IndexFilename)); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func writeOutputIndex(files []data_structures.OutputFile, filename string) error {
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		writer := csv.NewWriter(w)
+
+		if err := writer.Write([]string{"File", "Brand", "Type of System", "Rows"}); err != nil {
+			return fmt.Errorf("failed to write header: %w", err)
+		}
+		for _, file := range files {
+			row := []string{file.Filename, file.Brand, file.TypeOfSystem, strconv.Itoa(file.Rows)}
+			if err := writer.Write(row); err != nil {
+				return fmt.Errorf("failed to write row: %w", err)
+			}
+		}
+
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return fmt.Errorf("csv writer error: %w", err)
+		}
+		return nil
+	})
+}
+
+// uniqueFilename returns base+ext, numbered _2, _3, ... when an earlier file of the
+// same write already took that name (e.g. brands that only differ in punctuation)
+func uniqueFilename(used map[string]bool, base string, ext string) string {
+	filename := base + ext
+	for n := 2; used[filename]; n++ {
+		filename = base + "_" + strconv.Itoa(n) + ext
+	}
+	used[filename] = true
+	return filename
+}
+
+// sanitizeFilename replaces anything that isn't safe in a file name on Windows or Linux
+func sanitizeFilename(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "unnamed"
+	}
+	return b.String()
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+func TestWriteFileAtomicMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing os.FileMode // mode of the file being replaced, 0 for none
+		want     os.FileMode
+	}{
+		{name: "new file", want: 0o644},
+		{name: "keeps existing mode", existing: 0o600, want: 0o600},
+		{name: "keeps group writable mode", existing: 0o664, want: 0o664},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "matches.csv")
+			if tt.existing != 0 {
+				if err := os.WriteFile(filename, []byte("old"), tt.existing); err != nil {
+					t.Fatal(err)
+				}
+				// WriteFile's mode is subject to the umask
+				if err := os.Chmod(filename, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := writeFileAtomic(filename, func(w io.Writer) error {
+				_, err := io.WriteString(w, "new")
+				return err
+			})
+			if err != nil {
+				t.Fatalf("writeFileAtomic: %v", err)
+			}
+
+			info, err := os.Stat(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.Mode().Perm(); got != tt.want {
+				t.Errorf("mode = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteSplitOutput(t *testing.T) {
+	matches := []data_structures.OutputCSV{
+		{Brand: "Goodman", TypeOfSystem: "Split AC"},
+		{Brand: "Amana", TypeOfSystem: "Split AC"},
+		{Brand: "Goodman", TypeOfSystem: "Heat Pump"},
+		{Brand: "Goodman", TypeOfSystem: "Split AC"},
+	}
+
+	tests := []struct {
+		name         string
+		byBrand      bool
+		bySystemType bool
+		want         []string // filename:rows
+	}{
+		{name: "no split", want: []string{"certified_hvac_matches.csv:4"}},
+		{name: "by brand", byBrand: true, want: []string{"amana.csv:1", "goodman.csv:3"}},
+		{name: "by system type", bySystemType: true, want: []string{"heat_pump.csv:1", "split_ac.csv:3"}},
+		{
+			name:         "by brand and system type",
+			byBrand:      true,
+			bySystemType: true,
+			want:         []string{"amana_split_ac.csv:1", "goodman_heat_pump.csv:1", "goodman_split_ac.csv:2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files, err := WriteSplitOutput(matches, DefaultOutputLayout(), dir, tt.byBrand, tt.bySystemType)
+			if err != nil {
+				t.Fatalf("WriteSplitOutput: %v", err)
+			}
+
+			got := []string{}
+			for _, file := range files {
+				got = append(got, file.Filename+":"+strconv.Itoa(file.Rows))
+				if _, err := os.Stat(filepath.Join(dir, file.Filename)); err != nil {
+					t.Errorf("%s not written: %v", file.Filename, err)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("files = %v, want %v", got, tt.want)
+			}
+			if _, err := os.Stat(filepath.Join(dir, IndexFilename)); err != nil {
+				t.Errorf("index not written: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// WriteOutputJSON writes the matches, including their provenance, as an indented JSON array.
+func WriteOutputJSON(matches []data_structures.OutputCSV, filename string) error {
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(matches); err != nil {
+			return fmt.Errorf("failed to encode matches: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,306 @@
+package internal
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+var layoutFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+	"item":  listItem,
+}
+
+// listItem returns the i-th (0-based) entry of a list field such as IndoorHeads,
+// or "" when the list is shorter
+func listItem(list []string, i int) string {
+	if i < 0 || i >= len(list) {
+		return ""
+	}
+	return list[i]
+}
+
+// DefaultOutputLayout returns the column layout used when no template is supplied.
+func DefaultOutputLayout() data_structures.OutputLayout {
+	return data_structures.OutputLayout{
+		Columns: []data_structures.OutputColumn{
+			{Header: "AHRI Number", Field: "AHRINumber"},
+			{Header: "Brand", Field: "Brand"},
+			{Header: "Orientation", Field: "Orientation"},
+			{Header: "Type of System", Field: "TypeOfSystem"},
+			{Header: "Outdoor Unit", Field: "OutdoorUnit"},
+			{Header: "Furnace", Field: "Furnace"},
+			{Header: "Evaporator Coil", Field: "EvaporatorCoil"},
+			{Header: "Air Handler", Field: "AirHandler"},
+			{Header: "Cooling Capacity", Field: "CoolingCapacity"},
+			{Header: "SEER2", Field: "SEER2"},
+			{Header: "EER2", Field: "EER2"},
+			{Header: "HSPF2", Field: "HSPF2"},
+			{Header: "Heating Capacity (47F)", Field: "HeatingCapacity47"},
+			{Header: "Heating Capacity (17F)", Field: "HeatingCapacity17"},
+			{Header: "Other AHRI Numbers", Field: "OtherAHRINumbers"},
+			{Header: "Match Method", Field: "MatchMethod"},
+			{Header: "Wildcard Positions", Field: "WildcardPositions"},
+			{Header: "Confidence", Field: "Confidence"},
+			{Header: "AHRI Source Row", Field: "{{if .AHRISourceRow}}{{.AHRISourceRow}}{{end}}"},
+		},
+	}
+}
+
+// ComponentBrandColumns returns the columns showing each component's own brand,
+// added to the default layout when brands are matched across brand groups.
+func ComponentBrandColumns() []data_structures.OutputColumn {
+	return []data_structures.OutputColumn{
+		{Header: "Outdoor Unit Brand", Field: "OutdoorUnitBrand"},
+		{Header: "Furnace Brand", Field: "FurnaceBrand"},
+		{Header: "Indoor Unit Brand", Field: "IndoorUnitBrand"},
+	}
+}
+
+// IndoorHeadColumns returns one column per mini-split indoor head, "Indoor Head 1"
+// to "Indoor Head n", added to the default layout when the equipment list has heads.
+func IndoorHeadColumns(n int) []data_structures.OutputColumn {
+	columns := make([]data_structures.OutputColumn, 0, n)
+	for i := 0; i < n; i++ {
+		columns = append(columns, data_structures.OutputColumn{
+			Header: fmt.Sprintf("Indoor Head %d", i+1),
+			Field:  fmt.Sprintf("{{item .IndoorHeads %d}}", i),
+		})
+	}
+	return columns
+}
+
+// PackagedUnitColumns returns the column showing packaged units, added to the
+// default layout when the equipment list has packaged units.
+func PackagedUnitColumns() []data_structures.OutputColumn {
+	return []data_structures.OutputColumn{
+		{Header: "Packaged Unit", Field: "PackagedUnit"},
+	}
+}
+
+// RevisionColumns returns the column noting matches that relied on revision
+// tolerance, added to the default layout when a revision policy is in use.
+func RevisionColumns() []data_structures.OutputColumn {
+	return []data_structures.OutputColumn{
+		{Header: "Revision Tolerance", Field: "RevisionTolerance"},
+	}
+}
+
+// SupersessionColumns returns the column noting matches certified through a
+// replaced model, added to the default layout when a cross-reference is loaded.
+func SupersessionColumns() []data_structures.OutputColumn {
+	return []data_structures.OutputColumn{
+		{Header: "Superseded Models", Field: "SupersededModels"},
+	}
+}
+
+/*
+LoadOutputLayout reads a user supplied output layout.
+A .csv file is a column list with "Header" and "Field" columns, one row per output column.
+Any other file is treated as a text/template that receives the full slice of matches.
+*/
+func LoadOutputLayout(filename string) (data_structures.OutputLayout, error) {
+	if !strings.EqualFold(filepath.Ext(filename), ".csv") {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return data_structures.OutputLayout{}, fmt.Errorf("failed to read output template %s: %w", filename, err)
+		}
+		layout := data_structures.OutputLayout{Template: string(content)}
+		if _, err := template.New("output").Funcs(layoutFuncs).Parse(layout.Template); err != nil {
+			return data_structures.OutputLayout{}, fmt.Errorf("invalid output template %s: %w", filename, err)
+		}
+		return layout, nil
+	}
+
+	headers, err := GetCSVHeader(filename, []string{"Header", "Field"})
+	if err != nil {
+		return data_structures.OutputLayout{}, err
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return data_structures.OutputLayout{}, fmt.Errorf("there was an error with opening %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+
+	if _, err := r.Read(); err != nil {
+		return data_structures.OutputLayout{}, fmt.Errorf("error reading header: %w", err)
+	}
+
+	layout := data_structures.OutputLayout{}
+	headerIdx := headers["header"]
+	fieldIdx := headers["field"]
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return data_structures.OutputLayout{}, fmt.Errorf("error reading CSV: %w", err)
+		}
+		if len(record) <= headerIdx || len(record) <= fieldIdx {
+			continue
+		}
+
+		layout.Columns = append(layout.Columns, data_structures.OutputColumn{
+			Header: strings.TrimSpace(record[headerIdx]),
+			Field:  strings.TrimSpace(record[fieldIdx]),
+		})
+	}
+
+	if len(layout.Columns) == 0 {
+		return data_structures.OutputLayout{}, fmt.Errorf("output layout %s defines no columns", filename)
+	}
+
+	// Compile once here so a bad field expression is reported before any matching is done
+	if _, err := compileColumns(layout.Columns); err != nil {
+		return data_structures.OutputLayout{}, fmt.Errorf("invalid output layout %s: %w", filename, err)
+	}
+
+	return layout, nil
+}
+
+// compileColumns turns each column's field expression into a template.
+// A bare field name is shorthand for "{{.FieldName}}".
+func compileColumns(columns []data_structures.OutputColumn) ([]*template.Template, error) {
+	compiled := make([]*template.Template, 0, len(columns))
+
+	for _, col := range columns {
+		expr := col.Field
+		if !strings.Contains(expr, "{{") {
+			expr = "{{." + expr + "}}"
+		}
+
+		tmpl, err := template.New(col.Header).Funcs(layoutFuncs).Parse(expr)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %w", col.Header, err)
+		}
+
+		// Execute against an empty row to catch references to unknown fields
+		if err := tmpl.Execute(io.Discard, data_structures.OutputCSV{}); err != nil {
+			return nil, fmt.Errorf("column %q: %w", col.Header, err)
+		}
+		compiled = append(compiled, tmpl)
+	}
+
+	return compiled, nil
+}
+
+// WriteOutputLayout writes the matches to filename using the supplied layout.
+// The file is written to a temporary file and renamed into place, so readers
+// never see a partially written result.
+func WriteOutputLayout(matches []data_structures.OutputCSV, layout data_structures.OutputLayout, filename string) error {
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		return renderOutputLayout(w, matches, layout)
+	})
+}
+
+func renderOutputLayout(w io.Writer, matches []data_structures.OutputCSV, layout data_structures.OutputLayout) error {
+	if layout.Template != "" {
+		tmpl, err := template.New("output").Funcs(layoutFuncs).Parse(layout.Template)
+		if err != nil {
+			return fmt.Errorf("invalid output template: %w", err)
+		}
+		if err := tmpl.Execute(w, matches); err != nil {
+			return fmt.Errorf("failed to render output template: %w", err)
+		}
+		return nil
+	}
+
+	writeRow, flush, err := newLayoutRowWriter(w, layout)
+	if err != nil {
+		return err
+	}
+
+	for _, match := range matches {
+		if err := writeRow(match); err != nil {
+			return err
+		}
+	}
+
+	return flush()
+}
+
+/*
+WriteOutputStream writes rows to filename as produce emits them, so a run's matches
+never have to be collected before output. Rows appear in the order emitted (no sorting).
+The file is only moved into place once produce returns without error.
+Template layouts render the whole match list at once and so cannot be streamed.
+*/
+func WriteOutputStream(
+	layout data_structures.OutputLayout,
+	filename string,
+	produce func(emit func(data_structures.OutputCSV) error) error,
+) error {
+	if layout.Template != "" {
+		return fmt.Errorf("template output layouts cannot be streamed; use a column layout")
+	}
+
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		writeRow, flush, err := newLayoutRowWriter(w, layout)
+		if err != nil {
+			return err
+		}
+		if err := produce(writeRow); err != nil {
+			return err
+		}
+		return flush()
+	})
+}
+
+// newLayoutRowWriter writes the column layout's header to w and returns functions
+// to write one row at a time and to flush the rows written.
+func newLayoutRowWriter(w io.Writer, layout data_structures.OutputLayout) (func(data_structures.OutputCSV) error, func() error, error) {
+	columns, err := compileColumns(layout.Columns)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	writer := csv.NewWriter(w)
+
+	header := make([]string, len(layout.Columns))
+	for i, col := range layout.Columns {
+		header[i] = col.Header
+	}
+	if err := writer.Write(header); err != nil {
+		return nil, nil, fmt.Errorf("failed to write header: %w", err)
+	}
+
+	var cell strings.Builder
+	writeRow := func(match data_structures.OutputCSV) error {
+		row := make([]string, len(columns))
+		for i, tmpl := range columns {
+			cell.Reset()
+			if err := tmpl.Execute(&cell, match); err != nil {
+				return fmt.Errorf("failed to render column %q: %w", layout.Columns[i].Header, err)
+			}
+			row[i] = cell.String()
+		}
+		if err := writer.Write(row); err != nil {
+			return fmt.Errorf("failed to write row: %w", err)
+		}
+		return nil
+	}
+
+	flush := func() error {
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return fmt.Errorf("csv writer error: %w", err)
+		}
+		return nil
+	}
+
+	return writeRow, flush, nil
+}
@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+func TestWriteOutputLayout(t *testing.T) {
+	matches := []data_structures.OutputCSV{
+		{AHRINumber: "1001", Brand: "Goodman", TypeOfSystem: "central_ac_furnace", OutdoorUnit: "GSXN403010", Furnace: "GR9S800803", EvaporatorCoil: "CAPTA3026B4"},
+		{AHRINumber: "1002", Brand: "Amana", TypeOfSystem: "central_ac_air_handler", OutdoorUnit: "ASXN403010", AirHandler: "AMST30BU130"},
+	}
+
+	tests := []struct {
+		name   string
+		layout data_structures.OutputLayout
+		want   string
+	}{
+		{
+			name: "field names",
+			layout: data_structures.OutputLayout{Columns: []data_structures.OutputColumn{
+				{Header: "AHRI", Field: "AHRINumber"},
+				{Header: "Condenser", Field: "OutdoorUnit"},
+			}},
+			want: "AHRI,Condenser\n1001,GSXN403010\n1002,ASXN403010\n",
+		},
+		{
+			name: "template expressions",
+			layout: data_structures.OutputLayout{Columns: []data_structures.OutputColumn{
+				{Header: "Brand", Field: "{{.Brand | upper}}"},
+				{Header: "Indoor", Field: "{{if .AirHandler}}{{.AirHandler}}{{else}}{{.Furnace}} + {{.EvaporatorCoil}}{{end}}"},
+			}},
+			want: "Brand,Indoor\nGOODMAN,GR9S800803 + CAPTA3026B4\nAMANA,AMST30BU130\n",
+		},
+		{
+			name:   "free-form template",
+			layout: data_structures.OutputLayout{Template: `{{range .}}{{.AHRINumber}}: {{.OutdoorUnit | lower}}{{"\n"}}{{end}}`},
+			want:   "1001: gsxn403010\n1002: asxn403010\n",
+		},
+		{
+			name:   "default layout",
+			layout: data_structures.OutputLayout{Columns: DefaultOutputLayout().Columns[:2]},
+			want:   "AHRI Number,Brand\n1001,Goodman\n1002,Amana\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "matches.csv")
+			if err := WriteOutputLayout(matches, tt.layout, filename); err != nil {
+				t.Fatalf("WriteOutputLayout: %v", err)
+			}
+			got, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadOutputLayout(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    data_structures.OutputLayout
+		wantErr string
+	}{
+		{
+			name:    "column list",
+			file:    "layout.csv",
+			content: "Header,Field\nAHRI, AHRINumber \nName,{{.Brand}} {{.OutdoorUnit}}\n",
+			want: data_structures.OutputLayout{Columns: []data_structures.OutputColumn{
+				{Header: "AHRI", Field: "AHRINumber"},
+				{Header: "Name", Field: "{{.Brand}} {{.OutdoorUnit}}"},
+			}},
+		},
+		{
+			name:    "template file",
+			file:    "layout.txt",
+			content: "{{range .}}{{.AHRINumber}}{{end}}",
+			want:    data_structures.OutputLayout{Template: "{{range .}}{{.AHRINumber}}{{end}}"},
+		},
+		{name: "unknown field", file: "layout.csv", content: "Header,Field\nSEER,Seer\n", wantErr: `column "SEER"`},
+		{name: "no columns", file: "layout.csv", content: "Header,Field\n", wantErr: "defines no columns"},
+		{name: "bad template", file: "layout.txt", content: "{{range .}}", wantErr: "invalid output template"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(filename, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := LoadOutputLayout(filename)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadOutputLayout error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadOutputLayout: %v", err)
+			}
+			if got.Template != tt.want.Template || len(got.Columns) != len(tt.want.Columns) {
+				t.Fatalf("layout = %+v, want %+v", got, tt.want)
+			}
+			for i := range got.Columns {
+				if got.Columns[i] != tt.want.Columns[i] {
+					t.Errorf("column %d = %+v, want %+v", i, got.Columns[i], tt.want.Columns[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWriteOutputStream(t *testing.T) {
+	layout := data_structures.OutputLayout{Columns: []data_structures.OutputColumn{
+		{Header: "AHRI", Field: "AHRINumber"},
+		{Header: "Outdoor", Field: "OutdoorUnit"},
+	}}
+	rows := []data_structures.OutputCSV{
+		{AHRINumber: "1002", OutdoorUnit: "GSXN403010"},
+		{AHRINumber: "1001", OutdoorUnit: "GSXN406010"},
+	}
+	produce := func(emit func(data_structures.OutputCSV) error) error {
+		for _, row := range rows {
+			if err := emit(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	t.Run("rows in emit order", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "out.csv")
+		if err := WriteOutputStream(layout, filename, produce); err != nil {
+			t.Fatalf("WriteOutputStream: %v", err)
+		}
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "AHRI,Outdoor\n1002,GSXN403010\n1001,GSXN406010\n"
+		if string(got) != want {
+			t.Errorf("output = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("failed run leaves no file", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "out.csv")
+		err := WriteOutputStream(layout, filename, func(emit func(data_structures.OutputCSV) error) error {
+			if err := emit(rows[0]); err != nil {
+				return err
+			}
+			return os.ErrDeadlineExceeded
+		})
+		if err == nil {
+			t.Fatal("error from produce was not returned")
+		}
+		if _, err := os.Stat(filename); !os.IsNotExist(err) {
+			t.Errorf("partial output was written: %v", err)
+		}
+	})
+
+	t.Run("template layouts cannot stream", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "out.txt")
+		if err := WriteOutputStream(data_structures.OutputLayout{Template: "{{range .}}{{end}}"}, filename, produce); err == nil {
+			t.Error("template layout was streamed")
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+/*
+BuildCompatibilityMatrices pivots the matches into one matrix per brand and system type.
+Indoor columns are the air handler or evaporator coil, or "furnace + coil" when the
+system has both; mini-split columns list the system's heads joined with " + ".
+Systems without an outdoor unit (e.g. furnace only) and matches without an AHRI
+number are skipped, so every filled cell is a certified pairing.
+Matrices, rows and columns are all sorted so the result is deterministic.
+*/
+func BuildCompatibilityMatrices(matches []data_structures.OutputCSV) []data_structures.CompatibilityMatrix {
+	type matrixKey struct {
+		brand      string
+		systemType string
+	}
+
+	matrices := make(map[matrixKey]*data_structures.CompatibilityMatrix)
+	columns := make(map[matrixKey]map[string]bool)
+
+	for _, match := range matches {
+		if match.OutdoorUnit == "" || match.AHRINumber == "" {
+			continue
+		}
+
+		indoor := matrixIndoorLabel(match)
+		if indoor == "" {
+			continue
+		}
+
+		key := matrixKey{brand: match.Brand, systemType: match.TypeOfSystem}
+		matrix, exists := matrices[key]
+		if !exists {
+			matrix = &data_structures.CompatibilityMatrix{
+				Brand:        match.Brand,
+				TypeOfSystem: match.TypeOfSystem,
+				Cells:        make(map[string]map[string]string),
+			}
+			matrices[key] = matrix
+			columns[key] = make(map[string]bool)
+		}
+
+		row, exists := matrix.Cells[match.OutdoorUnit]
+		if !exists {
+			row = make(map[string]string)
+			matrix.Cells[match.OutdoorUnit] = row
+			matrix.OutdoorUnits = append(matrix.OutdoorUnits, match.OutdoorUnit)
+		}
+		if !columns[key][indoor] {
+			columns[key][indoor] = true
+			matrix.IndoorUnits = append(matrix.IndoorUnits, indoor)
+		}
+
+		row[indoor] = appendMatrixCell(row[indoor], match.AHRINumber)
+	}
+
+	result := make([]data_structures.CompatibilityMatrix, 0, len(matrices))
+	for _, matrix := range matrices {
+		sort.Strings(matrix.OutdoorUnits)
+		sort.Strings(matrix.IndoorUnits)
+		result = append(result, *matrix)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Brand != result[j].Brand {
+			return result[i].Brand < result[j].Brand
+		}
+		return result[i].TypeOfSystem < result[j].TypeOfSystem
+	})
+
+	return result
+}
+
+func matrixIndoorLabel(match data_structures.OutputCSV) string {
+	switch {
+	case len(match.IndoorHeads) > 0:
+		return strings.Join(match.IndoorHeads, " + ")
+	case match.Furnace != "" && match.EvaporatorCoil != "":
+		return match.Furnace + " + " + match.EvaporatorCoil
+	case match.AirHandler != "":
+		return match.AirHandler
+	case match.EvaporatorCoil != "":
+		return match.EvaporatorCoil
+	}
+	return ""
+}
+
+// appendMatrixCell adds an AHRI number to a cell, skipping duplicates
+func appendMatrixCell(cell string, ahriNumber string) string {
+	if cell == "" {
+		return ahriNumber
+	}
+	for _, existing := range strings.Split(cell, " / ") {
+		if existing == ahriNumber {
+			return cell
+		}
+	}
+	return cell + " / " + ahriNumber
+}
+
+/*
+WriteMatrixCSV writes each matrix to its own csv file in dir, named after its
+brand and system type, and returns the file names written. Pairs whose names
+sanitize to the same file name are numbered as in WriteSplitOutput.
+*/
+func WriteMatrixCSV(matrices []data_structures.CompatibilityMatrix, dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	filenames := make([]string, 0, len(matrices))
+	used := make(map[string]bool)
+	for _, matrix := range matrices {
+		filename := uniqueFilename(used, sanitizeFilename(matrix.Brand+"_"+matrix.TypeOfSystem)+"_matrix", ".csv")
+
+		err := writeFileAtomic(filepath.Join(dir, filename), func(w io.Writer) error {
+			writer := csv.NewWriter(w)
+
+			header := append([]string{"Outdoor Unit"}, matrix.IndoorUnits...)
+			if err := writer.Write(header); err != nil {
+				return fmt.Errorf("failed to write header: %w", err)
+			}
+
+			for _, outdoor := range matrix.OutdoorUnits {
+				row := make([]string, 0, len(matrix.IndoorUnits)+1)
+				row = append(row, outdoor)
+				for _, indoor := range matrix.IndoorUnits {
+					row = append(row, matrix.Cells[outdoor][indoor])
+				}
+				if err := writer.Write(row); err != nil {
+					return fmt.Errorf("failed to write row: %w", err)
+				}
+			}
+
+			writer.Flush()
+			if err := writer.Error(); err != nil {
+				return fmt.Errorf("csv writer error: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", filename, err)
+		}
+
+		filenames = append(filenames, filename)
+	}
+
+	return filenames, nil
+}
+
+var matrixHTMLTemplate = template.Must(template.New("matrix").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>HVAC Compatibility Matrix</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #999; padding: 4px 8px; text-align: center; white-space: nowrap; }
+th { background: #eee; }
+td.outdoor { text-align: left; font-weight: bold; }
+td.match { background: #d9f2d9; }
+</style>
+</head>
+<body>
+<h1>HVAC Compatibility Matrix</h1>
+{{range .}}{{$matrix := .}}
+<h2>{{.Brand}} &mdash; {{.TypeOfSystem}}</h2>
+<table>
+<tr><th>Outdoor Unit</th>{{range .IndoorUnits}}<th>{{.}}</th>{{end}}</tr>
+{{range $outdoor := .OutdoorUnits}}<tr><td class="outdoor">{{$outdoor}}</td>{{range $indoor := $matrix.IndoorUnits}}{{with index $matrix.Cells $outdoor $indoor}}<td class="match">{{.}}</td>{{else}}<td></td>{{end}}{{end}}</tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// WriteMatrixHTML writes every matrix into a single HTML page.
+func WriteMatrixHTML(matrices []data_structures.CompatibilityMatrix, filename string) error {
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		if err := matrixHTMLTemplate.Execute(w, matrices); err != nil {
+			return fmt.Errorf("failed to render matrix html: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+func TestBuildCompatibilityMatrices(t *testing.T) {
+	matches := []data_structures.OutputCSV{
+		{Brand: "Goodman", TypeOfSystem: "central_ac", OutdoorUnit: "GSXN403010", EvaporatorCoil: "CAPTA3026B4", AHRINumber: "1001"},
+		{Brand: "Goodman", TypeOfSystem: "central_ac", OutdoorUnit: "GSXN403010", EvaporatorCoil: "CAPTA3026B4", AHRINumber: "1002"},
+		{Brand: "Goodman", TypeOfSystem: "central_ac", OutdoorUnit: "GSXN403010", EvaporatorCoil: "CAPTA3026B4", AHRINumber: "1001"},
+		{Brand: "Goodman", TypeOfSystem: "central_ac", OutdoorUnit: "GSXN303010", Furnace: "GR9S800803BN", EvaporatorCoil: "CAPTA3026B4", AHRINumber: "1003"},
+		{Brand: "Goodman", TypeOfSystem: "heat_pump", OutdoorUnit: "GSZB403010", AirHandler: "AMST36BU1300", AHRINumber: "2001"},
+		// No AHRI number: not certified, so never shown as compatible
+		{Brand: "Goodman", TypeOfSystem: "heat_pump", OutdoorUnit: "GSZB403010", EvaporatorCoil: "CAPTA3026B4"},
+		// No outdoor unit
+		{Brand: "Goodman", TypeOfSystem: "furnace_only", Furnace: "GR9S800803BN", AHRINumber: "3001"},
+	}
+
+	got := BuildCompatibilityMatrices(matches)
+	if len(got) != 2 {
+		t.Fatalf("got %d matrices, want 2: %+v", len(got), got)
+	}
+
+	ac := got[0]
+	if ac.TypeOfSystem != "central_ac" {
+		t.Fatalf("first matrix = %s, want central_ac", ac.TypeOfSystem)
+	}
+	if !slices.Equal(ac.OutdoorUnits, []string{"GSXN303010", "GSXN403010"}) {
+		t.Errorf("outdoor units = %v", ac.OutdoorUnits)
+	}
+	if !slices.Equal(ac.IndoorUnits, []string{"CAPTA3026B4", "GR9S800803BN + CAPTA3026B4"}) {
+		t.Errorf("indoor units = %v", ac.IndoorUnits)
+	}
+	if cell := ac.Cells["GSXN403010"]["CAPTA3026B4"]; cell != "1001 / 1002" {
+		t.Errorf("cell = %q, want %q", cell, "1001 / 1002")
+	}
+
+	hp := got[1]
+	if !slices.Equal(hp.IndoorUnits, []string{"AMST36BU1300"}) {
+		t.Errorf("heat pump indoor units = %v, want only the certified air handler", hp.IndoorUnits)
+	}
+	if _, ok := hp.Cells["GSZB403010"]["CAPTA3026B4"]; ok {
+		t.Error("uncertified pairing appears in the matrix")
+	}
+}
+
+func TestWriteMatrixCSVFilenames(t *testing.T) {
+	matrix := func(brand, systemType string) data_structures.CompatibilityMatrix {
+		return data_structures.CompatibilityMatrix{
+			Brand:        brand,
+			TypeOfSystem: systemType,
+			OutdoorUnits: []string{"GSXN403010"},
+			IndoorUnits:  []string{"CAPTA3026B4"},
+			Cells:        map[string]map[string]string{"GSXN403010": {"CAPTA3026B4": "1001"}},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		matrices []data_structures.CompatibilityMatrix
+		want     []string
+	}{
+		{
+			name:     "distinct names",
+			matrices: []data_structures.CompatibilityMatrix{matrix("Amana", "central_ac"), matrix("Goodman", "central_ac")},
+			want:     []string{"amana_central_ac_matrix.csv", "goodman_central_ac_matrix.csv"},
+		},
+		{
+			name:     "names that sanitize alike",
+			matrices: []data_structures.CompatibilityMatrix{matrix("A/C Pro", "central_ac"), matrix("A.C Pro", "central_ac"), matrix("A C Pro", "central_ac")},
+			want:     []string{"a_c_pro_central_ac_matrix.csv", "a_c_pro_central_ac_matrix_2.csv", "a_c_pro_central_ac_matrix_3.csv"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := WriteMatrixCSV(tt.matrices, t.TempDir())
+			if err != nil {
+				t.Fatalf("WriteMatrixCSV: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("filenames = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"cmp"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// Output sort keys accepted by SortMatches
+const (
+	SortByBrand       = "brand"
+	SortBySystemType  = "system type"
+	SortByOutdoorUnit = "outdoor unit"
+	SortByAHRINumber  = "ahri number"
+	SortByRating      = "rating"
+)
+
+/*
+SortMatches orders the matches by the given keys, in priority order.
+"rating" sorts by SEER2 with the highest rated systems first; matches without
+a rating sort last. Ties keep their existing order, so the result is fully
+deterministic as long as the input order is.
+*/
+func SortMatches(matches []data_structures.OutputCSV, keys []string) error {
+	compares := make([]func(a, b data_structures.OutputCSV) int, 0, len(keys))
+
+	for _, key := range keys {
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case SortByBrand:
+			compares = append(compares, func(a, b data_structures.OutputCSV) int {
+				return strings.Compare(a.Brand, b.Brand)
+			})
+		case SortBySystemType:
+			compares = append(compares, func(a, b data_structures.OutputCSV) int {
+				return strings.Compare(a.TypeOfSystem, b.TypeOfSystem)
+			})
+		case SortByOutdoorUnit:
+			compares = append(compares, func(a, b data_structures.OutputCSV) int {
+				return strings.Compare(a.OutdoorUnit, b.OutdoorUnit)
+			})
+		case SortByAHRINumber:
+			compares = append(compares, func(a, b data_structures.OutputCSV) int {
+				return compareAHRINumbers(a.AHRINumber, b.AHRINumber)
+			})
+		case SortByRating:
+			compares = append(compares, func(a, b data_structures.OutputCSV) int {
+				return compareRatings(a.SEER2, b.SEER2)
+			})
+		default:
+			return fmt.Errorf("unknown sort key: %s", key)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		for _, compare := range compares {
+			if c := compare(matches[i], matches[j]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	return nil
+}
+
+// compareAHRINumbers compares AHRI reference numbers numerically when both are numbers
+func compareAHRINumbers(a, b string) int {
+	numA, errA := strconv.Atoi(a)
+	numB, errB := strconv.Atoi(b)
+	if errA == nil && errB == nil {
+		return cmp.Compare(numA, numB)
+	}
+	return strings.Compare(a, b)
+}
+
+// compareRatings orders the higher rating first, with missing or invalid ratings last
+func compareRatings(a, b string) int {
+	ratingA, errA := strconv.ParseFloat(strings.TrimSpace(a), 64)
+	ratingB, errB := strconv.ParseFloat(strings.TrimSpace(b), 64)
+
+	switch {
+	case errA != nil && errB != nil:
+		return 0
+	case errA != nil:
+		return 1
+	case errB != nil:
+		return -1
+	case ratingA > ratingB:
+		return -1
+	case ratingA < ratingB:
+		return 1
+	}
+	return 0
+}
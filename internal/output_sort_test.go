@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+func TestSortMatches(t *testing.T) {
+	match := func(ahriNumber, brand, systemType, outdoor, seer2 string) data_structures.OutputCSV {
+		return data_structures.OutputCSV{
+			AHRINumber:   ahriNumber,
+			Brand:        brand,
+			TypeOfSystem: systemType,
+			OutdoorUnit:  outdoor,
+			SEER2:        seer2,
+		}
+	}
+	matches := []data_structures.OutputCSV{
+		match("10", "Goodman", "central_ac", "GSXN403010", "14.3"),
+		match("9", "Amana", "central_ac_furnace", "ASXN403010", ""),
+		match("200", "Goodman", "air_source_heat_pump", "GSZB403010", "15.2"),
+		match("30", "Amana", "central_ac", "ASXN403010", "n/a"),
+		match("A7", "Goodman", "central_ac", "GSXN403010", "15.2"),
+	}
+
+	tests := []struct {
+		name    string
+		keys    []string
+		want    []string // AHRI numbers in order
+		wantErr bool
+	}{
+		{name: "no keys keeps the order", keys: nil, want: []string{"10", "9", "200", "30", "A7"}},
+		{name: "brand, ties in input order", keys: []string{"brand"}, want: []string{"9", "30", "10", "200", "A7"}},
+		{name: "AHRI numbers compare numerically", keys: []string{"ahri number"}, want: []string{"9", "10", "30", "200", "A7"}},
+		{name: "highest rating first, missing last", keys: []string{"rating"}, want: []string{"200", "A7", "10", "9", "30"}},
+		{name: "keys in priority order", keys: []string{"system type", "rating"}, want: []string{"200", "A7", "10", "30", "9"}},
+		{name: "outdoor unit then AHRI number", keys: []string{"outdoor unit", "ahri number"}, want: []string{"9", "30", "10", "A7", "200"}},
+		{name: "keys are trimmed and case insensitive", keys: []string{" Brand ", "AHRI Number"}, want: []string{"9", "30", "10", "200", "A7"}},
+		{name: "unknown key", keys: []string{"seer"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted := slices.Clone(matches)
+			err := SortMatches(sorted, tt.keys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SortMatches error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got := []string{}
+			for _, match := range sorted {
+				got = append(got, match.AHRINumber)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"context"
+	"iter"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// sampleSize is how many generated combinations are kept on each result for logging
+const sampleSize = 5
+
+/*
+BuildMatchJobs creates one job per brand group and system type, in the order given.
+Each group is a list of brands from GroupBrands whose equipment is combined across
+brands; a group of one brand is named after it, a larger group after all its brands.
+*/
+func BuildMatchJobs(equipmentList []data_structures.Equipment, brandGroups [][]string, systemTypes []string) []data_structures.MatchJob {
+	jobs := make([]data_structures.MatchJob, 0, len(brandGroups)*len(systemTypes))
+
+	for _, group := range brandGroups {
+		groupEquipment := equipmentOfBrands(equipmentList, group)
+		for _, sysType := range systemTypes {
+			jobs = append(jobs, data_structures.MatchJob{
+				Brand:      strings.Join(group, " / "),
+				SystemType: sysType,
+				Equipment:  groupEquipment,
+			})
+		}
+	}
+
+	return jobs
+}
+
+// BrandGroupEquipment returns the equipment brand may be paired with: that of every
+// brand in its group from GroupBrands, in the order its match jobs combine it
+func BrandGroupEquipment(equipmentList []data_structures.Equipment, brandGroups [][]string, brand string) []data_structures.Equipment {
+	for _, group := range brandGroups {
+		if slices.Contains(group, brand) {
+			return equipmentOfBrands(equipmentList, group)
+		}
+	}
+	return EquipmentSort(equipmentList, brand)
+}
+
+// equipmentOfBrands returns the equipment of each brand in turn
+func equipmentOfBrands(equipmentList []data_structures.Equipment, brands []string) []data_structures.Equipment {
+	equipment := []data_structures.Equipment{}
+	for _, brand := range brands {
+		equipment = append(equipment, EquipmentSort(equipmentList, brand)...)
+	}
+	return equipment
+}
+
+/*
+RunMatchJobs generates and matches every job on a pool of workers goroutines.
+Results are returned in job order, and rejection counts are merged into
+opts.Rejections in job order, so the output is identical to running the jobs
+one after another. Errors from a single job are reported on its result; if ctx
+is cancelled or times out the remaining jobs are abandoned and ctx's error returned.
+Every job shares the run's stock index (see BuildStockIndex).
+*/
+func RunMatchJobs(
+	ctx context.Context,
+	jobs []data_structures.MatchJob,
+	engine string,
+	ahriIndex *AHRIIndex,
+	stock *StockIndex,
+	opts data_structures.MatchOptions,
+	workers int,
+) ([]data_structures.MatchResult, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]data_structures.MatchResult, len(jobs))
+	rejections := make([]map[string]int, len(jobs))
+
+	jobIdx := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobIdx {
+				// Each job counts its own rejections so workers never share a map
+				jobOpts := opts
+				if opts.Rejections != nil {
+					rejections[i] = make(map[string]int)
+					jobOpts.Rejections = rejections[i]
+				}
+				results[i] = runMatchJob(ctx, jobs[i], engine, ahriIndex, stock, jobOpts)
+			}
+		}()
+	}
+
+feed:
+	for i := range jobs {
+		select {
+		case jobIdx <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobIdx)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if opts.Rejections != nil {
+		for _, jobRejections := range rejections {
+			for filter, count := range jobRejections {
+				opts.Rejections[filter] += count
+			}
+		}
+	}
+
+	return results, nil
+}
+
+func runMatchJob(
+	ctx context.Context,
+	job data_structures.MatchJob,
+	engine string,
+	ahriIndex *AHRIIndex,
+	stock *StockIndex,
+	opts data_structures.MatchOptions,
+) data_structures.MatchResult {
+	result := data_structures.MatchResult{Job: job}
+
+	matches := make([]data_structures.OutputCSV, 0)
+	err := StreamMatchJob(ctx, job, engine, ahriIndex, stock, opts, &result, func(output data_structures.OutputCSV) error {
+		matches = append(matches, output)
+		return nil
+	})
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Matches = matches
+
+	return result
+}
+
+/*
+StreamMatchJob generates and matches a single job, passing each certified match to
+emit as it is found rather than collecting them. The Cartesian engine's combinations
+are generated lazily, so neither they nor the matches are ever held in memory.
+The job's combination count and sample are recorded on result; its Matches are left alone.
+Multi-zone mini-splits are generated from the AHRI index by either engine (see
+certificationGenerated).
+*/
+func StreamMatchJob(
+	ctx context.Context,
+	job data_structures.MatchJob,
+	engine string,
+	ahriIndex *AHRIIndex,
+	stock *StockIndex,
+	opts data_structures.MatchOptions,
+	result *data_structures.MatchResult,
+	emit func(data_structures.OutputCSV) error,
+) error {
+	system, known := findSystemType(job.SystemType)
+
+	var combos iter.Seq[data_structures.ComponentKey]
+	if engine == EngineCertification || (known && system.certificationGenerated()) {
+		certified, err := GenerateCertifiedSystemEquipmentConfig(ctx, job.Equipment, job.SystemType, ahriIndex, stock, opts.CategoryPairings)
+		if err != nil {
+			return err
+		}
+		combos = slices.Values(certified)
+	} else {
+		seq, err := GenerateSystemEquipmentConfigSeq(ctx, job.Equipment, job.SystemType, opts.CategoryPairings)
+		if err != nil {
+			return err
+		}
+		combos = seq
+	}
+
+	// Keep the first few combinations for logging as they stream past
+	result.Sample = []data_structures.ComponentKey{}
+	sampled := func(yield func(data_structures.ComponentKey) bool) {
+		for combo := range combos {
+			if len(result.Sample) < sampleSize {
+				result.Sample = append(result.Sample, combo)
+			}
+			if !yield(combo) {
+				return
+			}
+		}
+	}
+
+	checked, err := StreamCertifiedMatches(ctx, sampled, ahriIndex, opts, emit)
+	result.Combinations = checked
+	return err
+}
@@ -0,0 +1,209 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// pipelineTestJobs is every system type for two brands stocking the engine test catalog
+func pipelineTestJobs() []data_structures.MatchJob {
+	list := engineTestEquipment()
+	for _, item := range engineTestEquipment() {
+		item.Brand = "Amana"
+		list = append(list, item)
+	}
+	return BuildMatchJobs(list, [][]string{{"Amana"}, {"Goodman"}}, engineTestSystemTypes)
+}
+
+// pipelineSisterBrands stocks the engine test catalog's outdoor units as Goodman and
+// everything else as Amana, so certified systems only exist across the two brands
+func pipelineSisterBrands() []data_structures.Equipment {
+	list := engineTestEquipment()
+	for i := range list {
+		list[i].Brand = "Amana"
+		if strings.HasPrefix(list[i].Type, "outdoor unit") {
+			list[i].Brand = "Goodman"
+		}
+	}
+	return list
+}
+
+func TestBuildMatchJobsBrandGroups(t *testing.T) {
+	list := pipelineSisterBrands()
+	ahriIndex := testAHRIIndex(t, engineTestRecords(), '%')
+	systemTypes := []string{"central ac & furnace"}
+
+	jobs := BuildMatchJobs(list, [][]string{{"Amana", "Goodman"}}, systemTypes)
+	if len(jobs) != 1 || jobs[0].Brand != "Amana / Goodman" || len(jobs[0].Equipment) != len(list) {
+		t.Fatalf("jobs = %+v, want one Amana / Goodman job with all the equipment", jobs)
+	}
+
+	for _, engine := range []string{EngineCartesian, EngineCertification} {
+		results, err := RunMatchJobs(context.Background(), jobs, engine, ahriIndex, nil, data_structures.MatchOptions{}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		matches := results[0].Matches
+		if len(matches) == 0 {
+			t.Fatalf("%s: no matches across the brand group", engine)
+		}
+		for _, m := range matches {
+			if m.Brand != "Goodman" || m.OutdoorUnitBrand != "Goodman" || m.IndoorUnitBrand != "Amana" || m.FurnaceBrand != "Amana" {
+				t.Errorf("%s: AHRI %s brands = %s (%s, %s, %s), want Goodman (Goodman, Amana, Amana)",
+					engine, m.AHRINumber, m.Brand, m.OutdoorUnitBrand, m.IndoorUnitBrand, m.FurnaceBrand)
+			}
+		}
+	}
+
+	// Ungrouped, neither brand has anything to pair
+	ungrouped := BuildMatchJobs(list, [][]string{{"Amana"}, {"Goodman"}}, systemTypes)
+	results, err := RunMatchJobs(context.Background(), ungrouped, EngineCartesian, ahriIndex, nil, data_structures.MatchOptions{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if len(result.Matches) != 0 {
+			t.Errorf("%s matched %d systems on its own", result.Job.Brand, len(result.Matches))
+		}
+	}
+}
+
+func TestBrandGroupEquipment(t *testing.T) {
+	list := pipelineSisterBrands()
+	amana := EquipmentSort(list, "Amana")
+	goodman := EquipmentSort(list, "Goodman")
+
+	// In group order, whichever brand is asked for
+	group := BrandGroupEquipment(list, [][]string{{"Amana", "Goodman"}}, "Goodman")
+	if !reflect.DeepEqual(group, append(slices.Clone(amana), goodman...)) {
+		t.Errorf("group equipment = %v", group)
+	}
+
+	if own := BrandGroupEquipment(list, nil, "Goodman"); !reflect.DeepEqual(own, goodman) {
+		t.Errorf("ungrouped equipment = %v, want %v", own, goodman)
+	}
+}
+
+func TestRunMatchJobsWorkers(t *testing.T) {
+	jobs := pipelineTestJobs()
+
+	for _, engine := range []string{EngineCartesian, EngineCertification} {
+		t.Run(engine, func(t *testing.T) {
+			ahriIndex := testAHRIIndex(t, engineTestRecords(), '%')
+
+			run := func(workers int) ([]data_structures.MatchResult, map[string]int) {
+				opts := data_structures.MatchOptions{Rejections: make(map[string]int)}
+				results, err := RunMatchJobs(context.Background(), jobs, engine, ahriIndex, nil, opts, workers)
+				if err != nil {
+					t.Fatalf("RunMatchJobs with %d workers: %v", workers, err)
+				}
+				return results, opts.Rejections
+			}
+
+			wantResults, wantRejections := run(1)
+			matched := 0
+			for _, result := range wantResults {
+				matched += len(result.Matches)
+			}
+			if matched == 0 || len(wantRejections) == 0 {
+				t.Fatalf("sequential run found %d matches and rejections %v, want some of both", matched, wantRejections)
+			}
+
+			for _, workers := range []int{0, 2, 8, 64} {
+				// Repeat so a scheduling dependent difference has a chance to show up
+				for range 5 {
+					results, rejections := run(workers)
+					if !reflect.DeepEqual(results, wantResults) {
+						t.Fatalf("%d workers: results differ from a sequential run", workers)
+					}
+					if !maps.Equal(rejections, wantRejections) {
+						t.Fatalf("%d workers: rejections = %v, want %v", workers, rejections, wantRejections)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRunMatchJobsCancelled(t *testing.T) {
+	jobs := pipelineTestJobs()
+	ahriIndex := testAHRIIndex(t, engineTestRecords(), '%')
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	timedOut, cancelTimeout := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancelTimeout()
+	<-timedOut.Done()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want error
+	}{
+		{name: "cancelled", ctx: cancelled, want: context.Canceled},
+		{name: "timed out", ctx: timedOut, want: context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		for _, workers := range []int{1, 4} {
+			opts := data_structures.MatchOptions{Rejections: make(map[string]int)}
+			results, err := RunMatchJobs(tt.ctx, jobs, EngineCartesian, ahriIndex, nil, opts, workers)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("%s with %d workers: err = %v, want %v", tt.name, workers, err, tt.want)
+			}
+			if results != nil {
+				t.Errorf("%s with %d workers: got %d partial results, want none", tt.name, workers, len(results))
+			}
+			if len(opts.Rejections) != 0 {
+				t.Errorf("%s with %d workers: rejections merged from abandoned jobs: %v", tt.name, workers, opts.Rejections)
+			}
+		}
+	}
+}
+
+func TestStreamMatchJob(t *testing.T) {
+	ahriIndex := testAHRIIndex(t, engineTestRecords(), '%')
+	opts := data_structures.MatchOptions{}
+
+	for _, job := range pipelineTestJobs() {
+		for _, engine := range []string{EngineCartesian, EngineCertification} {
+			want := runMatchJob(context.Background(), job, engine, ahriIndex, nil, opts)
+			if want.Err != nil {
+				t.Fatalf("%s %s: %v", job.Brand, job.SystemType, want.Err)
+			}
+
+			result := data_structures.MatchResult{Job: job}
+			got := []data_structures.OutputCSV{}
+			err := StreamMatchJob(context.Background(), job, engine, ahriIndex, nil, opts, &result, func(match data_structures.OutputCSV) error {
+				got = append(got, match)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("%s %s: StreamMatchJob: %v", job.Brand, job.SystemType, err)
+			}
+			if !reflect.DeepEqual(got, want.Matches) || result.Combinations != want.Combinations || !reflect.DeepEqual(result.Sample, want.Sample) {
+				t.Errorf("%s %s (%s): streamed result differs from the collected one", job.Brand, job.SystemType, engine)
+			}
+		}
+	}
+
+	// An emit error stops the job and is returned as is
+	stop := errors.New("disk full")
+	job := data_structures.MatchJob{Brand: "Goodman", SystemType: "furnace", Equipment: engineTestEquipment()}
+	emitted := 0
+	err := StreamMatchJob(context.Background(), job, EngineCartesian, ahriIndex, nil, opts, &data_structures.MatchResult{}, func(data_structures.OutputCSV) error {
+		emitted++
+		return stop
+	})
+	if !errors.Is(err, stop) || emitted != 1 {
+		t.Errorf("err = %v after %d rows, want %v after 1", err, emitted, stop)
+	}
+}
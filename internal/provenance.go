@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// Values for OutputCSV.MatchMethod
+const (
+	MatchExact          = "exact"
+	MatchWildcard       = "wildcard"
+	MatchRevision       = "revision tolerant"
+	MatchCrossReference = "cross-reference"
+	MatchAnyFurnace     = "any furnace"
+	MatchHeuristic      = "heuristic"
+
+	// MatchTonnageCompatible marks central ac systems that passed the tonnage filters
+	// but were not found in the AHRI index
+	MatchTonnageCompatible = "tonnage-compatible, not certified"
+)
+
+// Values for OutputCSV.Confidence
+const (
+	ConfidenceHigh   = "high"
+	ConfidenceMedium = "medium"
+	ConfidenceLow    = "low"
+)
+
+/*
+setProvenance records on output how the combination was matched to record.
+An exact match of every model is high confidence; one that relied on AHRI
+wildcards, revision tolerance or a record rated with a furnace the system
+doesn't have is medium; one certified only through a superseded model is low.
+Several methods are listed together, e.g. "wildcard, cross-reference", and the
+lowest confidence wins.
+*/
+func (index *AHRIIndex) setProvenance(output *data_structures.OutputCSV, combo data_structures.ComponentKey, record data_structures.AHRIRecord) {
+	methods := []string{}
+	confidence := ConfidenceHigh
+
+	anyFurnace := combo.Furnace.InputModelNumber == "" && record.Furnace.InputModelNumber != ""
+
+	positions := index.wildcardPositions(record, !anyFurnace)
+	if len(positions) > 0 {
+		methods = append(methods, MatchWildcard)
+		confidence = ConfidenceMedium
+	}
+	if output.RevisionTolerance != "" {
+		methods = append(methods, MatchRevision)
+		confidence = ConfidenceMedium
+	}
+	if anyFurnace {
+		methods = append(methods, MatchAnyFurnace)
+		confidence = ConfidenceMedium
+	}
+	if output.SupersededModels != "" {
+		methods = append(methods, MatchCrossReference)
+		confidence = ConfidenceLow
+	}
+	if len(methods) == 0 {
+		methods = append(methods, MatchExact)
+	}
+
+	output.MatchMethod = strings.Join(methods, ", ")
+	output.WildcardPositions = strings.Join(positions, ", ")
+	output.Confidence = confidence
+	output.AHRISourceRow = record.SourceRow
+}
+
+// setHeuristicProvenance marks a row produced by the filters alone, with no AHRI record
+func setHeuristicProvenance(output *data_structures.OutputCSV) {
+	output.MatchMethod = MatchHeuristic
+	output.Confidence = ConfidenceLow
+}
+
+// wildcardPositions lists the wildcard positions (1-based, in the indexed model)
+// of each component of the record, e.g. "indoor unit 3" or "indoor head 2 3".
+// The furnace is skipped unless withFurnace, since a furnace that wasn't matched
+// used none of its wildcards.
+func (index *AHRIIndex) wildcardPositions(record data_structures.AHRIRecord, withFurnace bool) []string {
+	positions := []string{}
+
+	type component struct {
+		name  string // the revision policy's component
+		label string // how the component is reported
+		rated data_structures.Equipment
+	}
+	components := []component{{ComponentOutdoorUnit, ComponentOutdoorUnit, record.OutdoorUnit}}
+	if len(record.IndoorHeads) > 0 {
+		// Multi-split heads are reported by their column in the AHRI record
+		for i, head := range record.IndoorHeads {
+			components = append(components, component{ComponentIndoorUnit, fmt.Sprintf("indoor head %d", i+1), head})
+		}
+	} else {
+		components = append(components, component{ComponentIndoorUnit, ComponentIndoorUnit, record.IndoorUnit})
+	}
+	components = append(components, component{ComponentFurnace, ComponentFurnace, record.Furnace})
+
+	for _, c := range components {
+		if c.name == ComponentFurnace && !withFurnace {
+			continue
+		}
+		model := index.modelKey(c.name, NormalizeString(c.rated))
+		for i := 0; i < len(model); i++ {
+			if model[i] == WildcardChar || (index.multiWildcard != 0 && model[i] == index.multiWildcard) {
+				positions = append(positions, fmt.Sprintf("%s %d", c.label, i+1))
+			}
+		}
+	}
+
+	return positions
+}
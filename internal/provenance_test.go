@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+func TestSetProvenance(t *testing.T) {
+	index := testAHRIIndex(t, nil, '%')
+	record := func(outdoor, indoor string) data_structures.AHRIRecord {
+		return data_structures.AHRIRecord{
+			AHRINumber:  "1001",
+			OutdoorUnit: data_structures.Equipment{InputModelNumber: outdoor},
+			IndoorUnit:  data_structures.Equipment{InputModelNumber: indoor},
+			SourceRow:   7,
+		}
+	}
+
+	tests := []struct {
+		name           string
+		record         data_structures.AHRIRecord
+		combo          data_structures.ComponentKey
+		output         data_structures.OutputCSV
+		wantMethod     string
+		wantPositions  string
+		wantConfidence string
+	}{
+		{
+			name:           "exact",
+			record:         record("GSXN403010", "CAPTA3026B4"),
+			wantMethod:     MatchExact,
+			wantConfidence: ConfidenceHigh,
+		},
+		{
+			name:           "wildcards",
+			record:         record("GSXN4*3010", "CA%"),
+			wantMethod:     MatchWildcard,
+			wantPositions:  "outdoor unit 6, indoor unit 3",
+			wantConfidence: ConfidenceMedium,
+		},
+		{
+			name:           "revision tolerant",
+			record:         record("GSXN403010", "CAPTA3026B4"),
+			output:         data_structures.OutputCSV{RevisionTolerance: ComponentOutdoorUnit},
+			wantMethod:     MatchRevision,
+			wantConfidence: ConfidenceMedium,
+		},
+		{
+			name: "any furnace",
+			record: func() data_structures.AHRIRecord {
+				r := record("GSXN403010", "CAPTA3026B4")
+				r.Furnace = data_structures.Equipment{InputModelNumber: "G*9S800803BN"}
+				return r
+			}(),
+			// The furnace's wildcard isn't reported: no furnace was matched against it
+			wantMethod:     MatchAnyFurnace,
+			wantConfidence: ConfidenceMedium,
+		},
+		{
+			name:           "lowest confidence wins",
+			record:         record("GSXN4*3010", "CAPTA3026B4"),
+			output:         data_structures.OutputCSV{SupersededModels: "CAPT3026B4 -> CAPTA3026B4"},
+			wantMethod:     MatchWildcard + ", " + MatchCrossReference,
+			wantPositions:  "outdoor unit 6",
+			wantConfidence: ConfidenceLow,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := tt.output
+			index.setProvenance(&output, tt.combo, tt.record)
+			if output.MatchMethod != tt.wantMethod || output.WildcardPositions != tt.wantPositions || output.Confidence != tt.wantConfidence {
+				t.Errorf("provenance = %q, %q, %q, want %q, %q, %q",
+					output.MatchMethod, output.WildcardPositions, output.Confidence, tt.wantMethod, tt.wantPositions, tt.wantConfidence)
+			}
+			if output.AHRISourceRow != 7 {
+				t.Errorf("AHRISourceRow = %d, want 7", output.AHRISourceRow)
+			}
+		})
+	}
+}
+
+func TestMatchProvenance(t *testing.T) {
+	list := engineTestEquipment()
+	ahriIndex := testAHRIIndex(t, engineTestRecords(), '%')
+
+	for _, sysType := range engineTestSystemTypes {
+		combos, err := GenerateFullSystemEquipmentConfig(context.Background(), list, sysType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		matches, err := FindCertifiedMatches(context.Background(), func(yield func(data_structures.ComponentKey) bool) {
+			for _, combo := range combos {
+				if !yield(combo) {
+					return
+				}
+			}
+		}, ahriIndex, data_structures.MatchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, match := range matches {
+			// Systems AHRI doesn't certify are matched by the filters alone
+			heuristic := match.AHRINumber == ""
+			if heuristic && (match.MatchMethod != MatchHeuristic || match.Confidence != ConfidenceLow) {
+				t.Errorf("%s: uncertified match %+v isn't marked heuristic", sysType, match)
+			}
+			if !heuristic && (match.MatchMethod == "" || match.MatchMethod == MatchHeuristic || match.Confidence == "") {
+				t.Errorf("%s: AHRI %s has provenance %q, %q", sysType, match.AHRINumber, match.MatchMethod, match.Confidence)
+			}
+		}
+	}
+}
+
+func TestCSVAHRIReaderSourceRow(t *testing.T) {
+	// The second record spans two lines; rows are file lines, not record numbers
+	content := "AHRI,Outdoor,Indoor,Furnace\n1001,GSXN403010,CAPTA3026B4,\n\"1002\",\"GSXN406010\",\"CAPTA\n3626B4\",\n1003,GSXN406010,CAPTA3626B4,\n"
+	records, err := CSVAHRIReader(writeTestCSV(t, content))
+	if err != nil {
+		t.Fatalf("CSVAHRIReader: %v", err)
+	}
+
+	got := []int{}
+	for _, record := range records {
+		got = append(got, record.SourceRow)
+	}
+	if want := []int{2, 3, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("source rows = %v, want %v", got, want)
+	}
+}
+
+func TestWriteOutputJSON(t *testing.T) {
+	matches := []data_structures.OutputCSV{
+		{AHRINumber: "1001", Brand: "Goodman", MatchMethod: MatchWildcard, WildcardPositions: "indoor unit 3", Confidence: ConfidenceMedium, AHRISourceRow: 2},
+		{Brand: "Goodman", Furnace: "GR9S800803BN", MatchMethod: MatchHeuristic, Confidence: ConfidenceLow},
+	}
+	filename := filepath.Join(t.TempDir(), "matches.json")
+
+	if err := WriteOutputJSON(matches, filename); err != nil {
+		t.Fatalf("WriteOutputJSON: %v", err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []data_structures.OutputCSV{}
+	if err := json.Unmarshal(content, &got); err != nil {
+		t.Fatalf("output isn't JSON: %v", err)
+	}
+	if !reflect.DeepEqual(got, matches) {
+		t.Errorf("round trip = %+v, want %+v", got, matches)
+	}
+
+	fields := []map[string]any{}
+	if err := json.Unmarshal(content, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields[0]["match_method"] != MatchWildcard || fields[0]["ahri_source_row"] != float64(2) {
+		t.Errorf("first match = %v, want snake case provenance fields", fields[0])
+	}
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+/*
+LoadRevisionRules reads the model revision policy from a csv file with the columns
+"Component" (outdoor unit, indoor unit or furnace), "Prefix" (the model family, blank
+for every model), "Suffix" (how many trailing characters are revision or voltage codes)
+and "Any Revision" (yes/no). The first rule matching a model applies.
+*/
+func LoadRevisionRules(filename string) ([]data_structures.RevisionRule, error) {
+	headers, err := GetCSVHeader(filename, []string{"Component", "Prefix", "Suffix", "Any Revision"})
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error with opening %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+
+	if _, err := r.Read(); err != nil {
+		return nil, fmt.Errorf("error reading header: %w", err)
+	}
+
+	rules := []data_structures.RevisionRule{}
+	line := 1
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV: %w", err)
+		}
+		line++
+
+		field := func(name string) string {
+			idx := headers[name]
+			if idx >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[idx])
+		}
+
+		component := strings.ToLower(field("component"))
+		if component != ComponentOutdoorUnit && component != ComponentIndoorUnit && component != ComponentFurnace {
+			return nil, fmt.Errorf("line %d: invalid component %q", line, field("component"))
+		}
+
+		suffix, err := strconv.Atoi(field("suffix"))
+		if err != nil || suffix < 0 {
+			return nil, fmt.Errorf("line %d: invalid suffix %q", line, field("suffix"))
+		}
+
+		var anyRevision bool
+		switch strings.ToLower(field("any revision")) {
+		case "yes", "y", "true":
+			anyRevision = true
+		case "no", "n", "false", "":
+			anyRevision = false
+		default:
+			return nil, fmt.Errorf("line %d: invalid any revision %q", line, field("any revision"))
+		}
+
+		rules = append(rules, data_structures.RevisionRule{
+			Component:   component,
+			Prefix:      strings.ToUpper(field("prefix")),
+			Suffix:      suffix,
+			AnyRevision: anyRevision,
+		})
+	}
+
+	return rules, nil
+}
+
+// revisionRule returns the first rule covering the model, if any
+func revisionRule(rules []data_structures.RevisionRule, component string, model string) (data_structures.RevisionRule, bool) {
+	model = strings.ToUpper(model)
+	for _, rule := range rules {
+		if rule.Component == component && strings.HasPrefix(model, rule.Prefix) {
+			return rule, true
+		}
+	}
+	return data_structures.RevisionRule{}, false
+}
+
+// splitRevision splits a model into its base and its revision suffix
+func splitRevision(model string, rule data_structures.RevisionRule) (string, string) {
+	if rule.Suffix >= len(model) {
+		return model, ""
+	}
+	return model[:len(model)-rule.Suffix], model[len(model)-rule.Suffix:]
+}
+
+/*
+modelKey is the model number used in index keys for a component. Models covered by
+a revision rule use the full model, or just its base when any revision is accepted;
+every other model uses its normalized (truncated) model number.
+*/
+func (index *AHRIIndex) modelKey(component string, equipment data_structures.Equipment) string {
+	if equipment.InputModelNumber == "" {
+		return equipment.NormalizedModelNumber
+	}
+
+	rule, covered := revisionRule(index.revisionRules, component, equipment.InputModelNumber)
+	if !covered {
+		return equipment.NormalizedModelNumber
+	}
+	if !rule.AnyRevision {
+		return equipment.InputModelNumber
+	}
+
+	base, _ := splitRevision(equipment.InputModelNumber, rule)
+	return base
+}
+
+// revisionTolerance lists the components of combo that are only certified by record
+// because any revision is accepted, i.e. whose revision suffixes differ
+func (index *AHRIIndex) revisionTolerance(combo data_structures.ComponentKey, record data_structures.AHRIRecord) []string {
+	tolerated := []string{}
+
+	for _, c := range index.componentPairs(combo, record) {
+		stocked := c.stocked.InputModelNumber
+		if stocked == "" || slices.Contains(tolerated, c.name) {
+			continue
+		}
+		rule, covered := revisionRule(index.revisionRules, c.name, stocked)
+		if !covered || !rule.AnyRevision {
+			continue
+		}
+		_, stockedRevision := splitRevision(stocked, rule)
+		_, ratedRevision := splitRevision(c.rated.InputModelNumber, rule)
+		if !index.matchModel(ratedRevision, stockedRevision) {
+			tolerated = append(tolerated, c.name)
+		}
+	}
+
+	return tolerated
+}
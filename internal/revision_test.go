@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+func TestLoadRevisionRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []data_structures.RevisionRule
+		wantErr string
+	}{
+		{
+			name:    "rules in file order",
+			content: "Component,Prefix,Suffix,Any Revision\nOutdoor Unit,gsxn,2,yes\nindoor unit,,0,\nfurnace,GR9,3,no\n",
+			want: []data_structures.RevisionRule{
+				{Component: ComponentOutdoorUnit, Prefix: "GSXN", Suffix: 2, AnyRevision: true},
+				{Component: ComponentIndoorUnit, Prefix: "", Suffix: 0},
+				{Component: ComponentFurnace, Prefix: "GR9", Suffix: 3},
+			},
+		},
+		{name: "unknown component", content: "Component,Prefix,Suffix,Any Revision\ncondenser,GSXN,2,yes\n", wantErr: "line 2"},
+		{name: "bad suffix", content: "Component,Prefix,Suffix,Any Revision\noutdoor unit,GSXN,-1,yes\n", wantErr: "invalid suffix"},
+		{name: "bad any revision", content: "Component,Prefix,Suffix,Any Revision\noutdoor unit,GSXN,2,maybe\n", wantErr: "invalid any revision"},
+		{name: "missing column", content: "Component,Prefix,Suffix\noutdoor unit,GSXN,2\n", wantErr: "Any Revision"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := LoadRevisionRules(writeTestCSV(t, tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadRevisionRules: %v", err)
+			}
+			if !reflect.DeepEqual(rules, tt.want) {
+				t.Errorf("rules = %+v, want %+v", rules, tt.want)
+			}
+		})
+	}
+}
+
+// revisionTestIndex indexes records under a policy that accepts any revision of
+// GSXN outdoor units and requires the full model of CAPTA coils
+func revisionTestIndex(t *testing.T, records []data_structures.AHRIRecord) *AHRIIndex {
+	t.Helper()
+	index, err := BuildAHRIIndex(records, data_structures.AHRIIndexOptions{RevisionRules: []data_structures.RevisionRule{
+		{Component: ComponentOutdoorUnit, Prefix: "GSXN", Suffix: 2, AnyRevision: true},
+		{Component: ComponentIndoorUnit, Prefix: "CAPTA", Suffix: 2},
+	}})
+	if err != nil {
+		t.Fatalf("BuildAHRIIndex: %v", err)
+	}
+	return index
+}
+
+func TestModelKey(t *testing.T) {
+	index := revisionTestIndex(t, nil)
+
+	tests := []struct {
+		component string
+		model     string
+		want      string
+	}{
+		{ComponentOutdoorUnit, "GSXN403010AA", "GSXN403010"},
+		{ComponentOutdoorUnit, "gsxn403010AA", "gsxn403010"}, // prefixes ignore case
+		{ComponentIndoorUnit, "CAPTA3026B4AA", "CAPTA3026B4AA"},
+		{ComponentIndoorUnit, "GSXN403010AA", "GSXN403010A"}, // the rule is for outdoor units
+		{ComponentFurnace, "GR9S800803BNAA", "GR9S800803B"},
+		{ComponentOutdoorUnit, "", ""},
+	}
+
+	for _, tt := range tests {
+		equipment := NormalizeString(data_structures.Equipment{InputModelNumber: tt.model})
+		if got := index.modelKey(tt.component, equipment); got != tt.want {
+			t.Errorf("modelKey(%s, %q) = %q, want %q", tt.component, tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestRevisionPolicyMatching(t *testing.T) {
+	index := revisionTestIndex(t, []data_structures.AHRIRecord{
+		{
+			AHRINumber:  "1001",
+			OutdoorUnit: data_structures.Equipment{InputModelNumber: "GSXN403010AA"},
+			IndoorUnit:  data_structures.Equipment{InputModelNumber: "CAPTA3026B4AA"},
+		},
+	})
+	combo := func(outdoor, indoor string) data_structures.ComponentKey {
+		return data_structures.ComponentKey{
+			Brand:       "Goodman",
+			SystemType:  systemTypeValue("heat pump"),
+			OutdoorUnit: NormalizeString(data_structures.Equipment{InputModelNumber: outdoor, Type: "outdoor unit (hp)"}),
+			IndoorUnit:  NormalizeString(data_structures.Equipment{InputModelNumber: indoor, Type: "evaporator coil"}),
+		}
+	}
+
+	tests := []struct {
+		name          string
+		combo         data_structures.ComponentKey
+		wantCertified bool
+		wantTolerance []string
+	}{
+		{name: "rated revisions", combo: combo("GSXN403010AA", "CAPTA3026B4AA"), wantCertified: true, wantTolerance: []string{}},
+		{name: "any outdoor unit revision", combo: combo("GSXN403010BB", "CAPTA3026B4AA"), wantCertified: true, wantTolerance: []string{ComponentOutdoorUnit}},
+		{name: "coil revision must match", combo: combo("GSXN403010AA", "CAPTA3026B4BB")},
+		{name: "outdoor unit base must match", combo: combo("GSXN406010AA", "CAPTA3026B4AA")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, certified := FindAHRICertification(tt.combo, index)
+			if certified != tt.wantCertified {
+				t.Fatalf("certified = %v, want %v", certified, tt.wantCertified)
+			}
+			if !certified {
+				return
+			}
+			if got := index.revisionTolerance(tt.combo, records[0]); !slices.Equal(got, tt.wantTolerance) {
+				t.Errorf("revisionTolerance = %v, want %v", got, tt.wantTolerance)
+			}
+		})
+	}
+}
+
+func TestRevisionPolicyEngines(t *testing.T) {
+	list := []data_structures.Equipment{}
+	for _, e := range []struct{ equipType, model string }{
+		{"outdoor unit (hp)", "GSZB403010"},
+		{"outdoor unit (hp)", "GSZB406010"},
+		{"evaporator coil", "CAPTA3026B4BB"},
+		{"furnace", "GR9S800803BN"},
+	} {
+		item := NormalizeString(data_structures.Equipment{InputModelNumber: e.model, Brand: "Goodman", Type: e.equipType})
+		list = append(list, AssignOrientation(CategorizeEquipment(item, DefaultCategoryRules()), DefaultOrientationRules()))
+	}
+
+	// Any revision of the rated coil is accepted
+	index, err := BuildAHRIIndex([]data_structures.AHRIRecord{
+		{
+			AHRINumber:  "1001",
+			OutdoorUnit: data_structures.Equipment{InputModelNumber: "GSZB403010"},
+			IndoorUnit:  data_structures.Equipment{InputModelNumber: "CAPTA3026B4AA"},
+			Furnace:     data_structures.Equipment{InputModelNumber: "GR9S800803BN"},
+		},
+	}, data_structures.AHRIIndexOptions{RevisionRules: []data_structures.RevisionRule{
+		{Component: ComponentIndoorUnit, Prefix: "CAPTA", Suffix: 2, AnyRevision: true},
+	}})
+	if err != nil {
+		t.Fatalf("BuildAHRIIndex: %v", err)
+	}
+
+	for _, engine := range []string{EngineCartesian, EngineCertification} {
+		job := data_structures.MatchJob{Brand: "Goodman", SystemType: "heat pump & furnace", Equipment: list}
+		result := runMatchJob(context.Background(), job, engine, index, nil, data_structures.MatchOptions{})
+		if result.Err != nil {
+			t.Fatalf("%s: %v", engine, result.Err)
+		}
+		if len(result.Matches) != 1 {
+			t.Fatalf("%s: matches = %+v, want one", engine, result.Matches)
+		}
+		match := result.Matches[0]
+		if match.OutdoorUnit != "GSZB403010" || match.EvaporatorCoil != "CAPTA3026B4BB" || match.RevisionTolerance != ComponentIndoorUnit {
+			t.Errorf("%s: match = %+v, want GSZB403010 with CAPTA3026B4BB, indoor unit revision tolerated", engine, match)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// NewRunStats returns an empty stats document with all maps initialised.
+func NewRunStats(startedAt time.Time) *data_structures.RunStats {
+	return &data_structures.RunStats{
+		StartedAt:           startedAt.Format(time.RFC3339),
+		InputFiles:          make(map[string]string),
+		EquipmentByBrand:    make(map[string]int),
+		EquipmentByCategory: make(map[string]int),
+		MatchesByBrand:      make(map[string]int),
+		MatchesBySystemType: make(map[string]int),
+		MatchesByCategory:   make(map[string]int),
+		Rejections:          make(map[string]int),
+		Stages:              []data_structures.StageTiming{},
+	}
+}
+
+// RecordStage appends the time elapsed since start as a named stage.
+func RecordStage(stats *data_structures.RunStats, name string, start time.Time) {
+	stats.Stages = append(stats.Stages, data_structures.StageTiming{
+		Name:    name,
+		Seconds: time.Since(start).Seconds(),
+	})
+}
+
+// RecordInputFile stores the sha256 of an input file so runs can be tied to their inputs.
+func RecordInputFile(stats *data_structures.RunStats, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("there was an error with opening %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return fmt.Errorf("failed to hash %s: %w", filename, err)
+	}
+
+	stats.InputFiles[filename] = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+// RecordEquipment counts the loaded equipment by brand and category.
+func RecordEquipment(stats *data_structures.RunStats, list []data_structures.Equipment) {
+	stats.EquipmentLoaded = len(list)
+	for _, equip := range list {
+		stats.EquipmentByBrand[equip.Brand]++
+		stats.EquipmentByCategory[equip.Category]++
+	}
+}
+
+// RecordMatches counts the output rows by match method, brand, system type and category.
+func RecordMatches(stats *data_structures.RunStats, matches []data_structures.OutputCSV) {
+	for _, match := range matches {
+		RecordMatch(stats, match)
+	}
+}
+
+// RecordMatch counts a single output row, for runs that stream their output. Rows
+// without an AHRI record (heuristic and tonnage-compatible) aren't certified matches.
+func RecordMatch(stats *data_structures.RunStats, match data_structures.OutputCSV) {
+	switch match.MatchMethod {
+	case MatchHeuristic:
+		stats.HeuristicMatches++
+	case MatchTonnageCompatible:
+		stats.TonnageCompatible++
+	default:
+		stats.CertifiedMatches++
+	}
+	stats.MatchesByBrand[match.Brand]++
+	stats.MatchesBySystemType[match.TypeOfSystem]++
+	stats.MatchesByCategory[match.Category]++
+}
+
+// WriteStatsJSON writes the stats document as indented JSON.
+func WriteStatsJSON(stats *data_structures.RunStats, filename string) error {
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(stats); err != nil {
+			return fmt.Errorf("failed to encode stats: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+func TestRecordMatches(t *testing.T) {
+	stats := NewRunStats(time.Now())
+	RecordMatches(stats, []data_structures.OutputCSV{
+		{Brand: "Goodman", TypeOfSystem: "central_ac", Category: data_structures.CategoryStandard},
+		{Brand: "Goodman", TypeOfSystem: "heat_pump", Category: data_structures.CategoryCommunicating},
+		{Brand: "Amana", TypeOfSystem: "central_ac", Category: data_structures.CategoryStandard},
+		{Brand: "Amana", TypeOfSystem: "furnace", Category: data_structures.CategoryStandard, MatchMethod: MatchHeuristic},
+		{Brand: "Goodman", TypeOfSystem: "central_ac_tonnage_compatible_not_certified", Category: data_structures.CategoryStandard, MatchMethod: MatchTonnageCompatible},
+	})
+
+	// Rows without an AHRI record are counted apart from the certified matches
+	if stats.CertifiedMatches != 3 || stats.HeuristicMatches != 1 || stats.TonnageCompatible != 1 {
+		t.Errorf("certified, heuristic, tonnage compatible = %d, %d, %d, want 3, 1, 1",
+			stats.CertifiedMatches, stats.HeuristicMatches, stats.TonnageCompatible)
+	}
+	tests := []struct {
+		name string
+		got  map[string]int
+		want map[string]int
+	}{
+		{"by brand", stats.MatchesByBrand, map[string]int{"Goodman": 3, "Amana": 2}},
+		{"by system type", stats.MatchesBySystemType, map[string]int{"central_ac": 2, "heat_pump": 1, "furnace": 1, "central_ac_tonnage_compatible_not_certified": 1}},
+		{"by category", stats.MatchesByCategory, map[string]int{"standard": 4, "communicating": 1}},
+	}
+	for _, tt := range tests {
+		if !maps.Equal(tt.got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestSystemCategory(t *testing.T) {
+	standard := data_structures.Equipment{Category: data_structures.CategoryStandard}
+	communicating := data_structures.Equipment{Category: data_structures.CategoryCommunicating}
+
+	tests := []struct {
+		name  string
+		combo data_structures.ComponentKey
+		want  string
+	}{
+		{name: "outdoor unit decides", combo: data_structures.ComponentKey{OutdoorUnit: communicating, IndoorUnit: communicating, Furnace: standard}, want: "communicating"},
+		{name: "no outdoor unit", combo: data_structures.ComponentKey{IndoorUnit: communicating}, want: "communicating"},
+		{name: "furnace only", combo: data_structures.ComponentKey{Furnace: standard}, want: "standard"},
+		{name: "nothing", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := systemCategory(tt.combo); got != tt.want {
+				t.Errorf("systemCategory = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteStatsJSON(t *testing.T) {
+	stats := NewRunStats(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	RecordEquipment(stats, []data_structures.Equipment{{Brand: "Goodman", Category: data_structures.CategoryStandard}})
+	stats.Rejections[RejectTonnage] = 4
+
+	filename := filepath.Join(t.TempDir(), "run_stats.json")
+	if err := WriteStatsJSON(stats, filename); err != nil {
+		t.Fatalf("WriteStatsJSON: %v", err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got data_structures.RunStats
+	if err := json.Unmarshal(content, &got); err != nil {
+		t.Fatalf("stats are not valid JSON: %v", err)
+	}
+	if got.StartedAt != "2026-01-02T03:04:05Z" || got.EquipmentLoaded != 1 || got.Rejections[RejectTonnage] != 4 {
+		t.Errorf("round trip = %+v", got)
+	}
+	// Empty maps are written as objects, not null, so consumers can always index them
+	if got.MatchesByCategory == nil {
+		t.Error("matches_by_category written as null")
+	}
+}
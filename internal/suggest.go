@@ -0,0 +1,313 @@
+package internal
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// suggestMaxDistance is the largest total model number edit distance for an
+// alternative that changes more than one component
+const suggestMaxDistance = 3
+
+// Component names used in Suggestion.Changed
+const (
+	ComponentOutdoorUnit = "outdoor unit"
+	ComponentIndoorUnit  = "indoor unit"
+	ComponentFurnace     = "furnace"
+)
+
+/*
+SuggestAlternatives finds certified combinations from stock that are close to a
+requested combination, for when the request itself isn't certified. An alternative
+qualifies if it differs from the request by one component (same outdoor unit with a
+different coil, etc.), however far apart those models are, or if it changes more
+components but its models are within suggestMaxDistance total edits of the requested
+ones. Models are compared by their AHRI model keys (see modelKey), so revision rules
+apply. Alternatives must pass the same filters as the normal output.
+
+Results are ranked by the number of components changed, then edit distance, then
+AHRI number, so any single component swap ranks above any multi component one. They
+are trimmed to limit (0 for all). Stock is the equipment the request's brand may be
+paired with (see BrandGroupEquipment); stockIndex is the run's StockIndex, or nil to
+index the stock. Only AHRI certified system types with a single indoor unit can
+have suggestions.
+*/
+func SuggestAlternatives(
+	ctx context.Context,
+	requested data_structures.ComponentKey,
+	stock []data_structures.Equipment,
+	ahriIndex *AHRIIndex,
+	stockIndex *StockIndex,
+	opts data_structures.MatchOptions,
+	limit int,
+) ([]data_structures.Suggestion, error) {
+	system, known := systemTypeByValue(requested.SystemType)
+	if !known {
+		return nil, fmt.Errorf("unknown system type: %s", requested.SystemType)
+	}
+	if !system.ahriDriven() {
+		return nil, fmt.Errorf("%s systems are not AHRI certified", system.Name)
+	}
+	if len(system.Heads) > 0 {
+		return nil, fmt.Errorf("suggestions are not available for %s systems", system.Name)
+	}
+
+	candidates, err := GenerateCertifiedSystemEquipmentConfig(ctx, stock, system.Name, ahriIndex, stockIndex, opts.CategoryPairings)
+	if err != nil {
+		return nil, err
+	}
+
+	// Suggestions are lookups, not part of the run, so they don't count as rejections
+	opts.Rejections = nil
+	ignore := func(string) {}
+
+	suggestions := []data_structures.Suggestion{}
+	for _, combo := range candidates {
+		changed, distance := compareCombos(requested, combo, ahriIndex)
+		if len(changed) == 0 {
+			continue
+		}
+		if len(changed) > 1 && distance > suggestMaxDistance {
+			continue
+		}
+
+		rows := matchCombo(combo, ahriIndex, opts, ignore)
+		if len(rows) == 0 {
+			continue
+		}
+
+		suggestions = append(suggestions, data_structures.Suggestion{
+			Combo:    combo,
+			Match:    rows[0],
+			Changed:  changed,
+			Distance: distance,
+		})
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if len(a.Changed) != len(b.Changed) {
+			return len(a.Changed) < len(b.Changed)
+		}
+		if a.Distance != b.Distance {
+			return a.Distance < b.Distance
+		}
+		return compareAHRINumbers(a.Match.AHRINumber, b.Match.AHRINumber) < 0
+	})
+
+	if limit > 0 && len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	return suggestions, nil
+}
+
+// compareCombos lists the components that differ between two combinations and
+// the total edit distance between their AHRI model keys
+func compareCombos(a, b data_structures.ComponentKey, ahriIndex *AHRIIndex) ([]string, int) {
+	changed := []string{}
+	distance := 0
+
+	components := []struct {
+		name string
+		a, b data_structures.Equipment
+	}{
+		{ComponentOutdoorUnit, a.OutdoorUnit, b.OutdoorUnit},
+		{ComponentIndoorUnit, a.IndoorUnit, b.IndoorUnit},
+		{ComponentFurnace, a.Furnace, b.Furnace},
+	}
+
+	for _, c := range components {
+		keyA, keyB := ahriIndex.modelKey(c.name, c.a), ahriIndex.modelKey(c.name, c.b)
+		if keyA == keyB {
+			continue
+		}
+		changed = append(changed, c.name)
+		distance += editDistance(keyA, keyB)
+	}
+
+	return changed, distance
+}
+
+/*
+LookupCombination looks up a single combination outside a run, as the lookup
+command does: it returns the AHRI records certifying requested or, when there are
+none, up to limit certified alternatives from the stock of requested's brand group
+(see SuggestAlternatives). brandGroups are the groups from GroupBrands and
+stockIndex the StockIndex of equipmentList, or nil.
+*/
+func LookupCombination(
+	ctx context.Context,
+	requested data_structures.ComponentKey,
+	equipmentList []data_structures.Equipment,
+	brandGroups [][]string,
+	ahriIndex *AHRIIndex,
+	stockIndex *StockIndex,
+	opts data_structures.MatchOptions,
+	limit int,
+) (data_structures.LookupResult, error) {
+	if records, certified := FindAHRICertification(requested, ahriIndex); certified {
+		return data_structures.LookupResult{Records: records}, nil
+	}
+
+	stock := BrandGroupEquipment(equipmentList, brandGroups, requested.Brand)
+	suggestions, err := SuggestAlternatives(ctx, requested, stock, ahriIndex, stockIndex, opts, limit)
+	if err != nil {
+		return data_structures.LookupResult{}, err
+	}
+	return data_structures.LookupResult{Suggestions: suggestions}, nil
+}
+
+// editDistance is the Levenshtein distance between two model numbers
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+/*
+LoadSuggestionRequests reads the combinations to suggest alternatives for from a
+csv file with the columns "Brand", "System Type", "Outdoor Unit", "Indoor Unit"
+and "Furnace" (blank when the system has none; a packaged unit goes in Outdoor
+Unit). Each row becomes a request as NewLookupRequest builds it.
+*/
+func LoadSuggestionRequests(filename string, equipmentList []data_structures.Equipment) ([]data_structures.ComponentKey, error) {
+	headers, err := GetCSVHeader(filename, []string{"Brand", "System Type", "Outdoor Unit", "Indoor Unit", "Furnace"})
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error with opening %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+
+	if _, err := r.Read(); err != nil {
+		return nil, fmt.Errorf("error reading header: %w", err)
+	}
+
+	field := func(record []string, column string) string {
+		idx := headers[strings.ToLower(column)]
+		if idx >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[idx])
+	}
+
+	requests := []data_structures.ComponentKey{}
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV: %w", err)
+		}
+
+		request, err := NewLookupRequest(equipmentList,
+			field(record, "Brand"),
+			field(record, "System Type"),
+			field(record, "Outdoor Unit"),
+			field(record, "Indoor Unit"),
+			field(record, "Furnace"))
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", filename, line, err)
+		}
+		requests = append(requests, request)
+	}
+
+	return requests, nil
+}
+
+/*
+NewLookupRequest builds the combination to look up from its model numbers.
+sysType is one of the names used in main.go, e.g. "heat pump & furnace"; a
+packaged system's unit is its outdoor model. Models we stock are taken from
+equipmentList; models we don't are still accepted and normalized so near matches
+can be found.
+*/
+func NewLookupRequest(
+	equipmentList []data_structures.Equipment,
+	brand string,
+	sysType string,
+	outdoor string,
+	indoor string,
+	furnace string,
+) (data_structures.ComponentKey, error) {
+	sysType = strings.ToLower(strings.TrimSpace(sysType))
+	system, known := findSystemType(sysType)
+	if !known || !system.ahriDriven() {
+		return data_structures.ComponentKey{}, fmt.Errorf("%q is not an AHRI certified system type", sysType)
+	}
+	if len(system.Heads) > 0 {
+		return data_structures.ComponentKey{}, fmt.Errorf("suggestions are not available for %s systems", sysType)
+	}
+
+	// Brands are matched case insensitively but reported as the equipment list spells them
+	brand = strings.TrimSpace(brand)
+	for _, item := range equipmentList {
+		if strings.EqualFold(item.Brand, brand) {
+			brand = item.Brand
+			break
+		}
+	}
+
+	request := data_structures.ComponentKey{
+		Brand:       brand,
+		SystemType:  system.Value,
+		OutdoorUnit: requestedEquipment(equipmentList, brand, outdoor, system.Outdoor),
+	}
+	if system.Indoor != "" {
+		request.IndoorUnit = requestedEquipment(equipmentList, brand, indoor, system.Indoor)
+	}
+	if system.Furnace {
+		request.Furnace = requestedEquipment(equipmentList, brand, furnace, "furnace")
+	}
+	return request, nil
+}
+
+// requestedEquipment returns the stocked equipment with the model number, or a
+// normalized stand in if we don't stock it
+func requestedEquipment(equipmentList []data_structures.Equipment, brand string, model string, role string) data_structures.Equipment {
+	for _, item := range equipmentList {
+		if !strings.EqualFold(item.Brand, brand) || !strings.EqualFold(item.InputModelNumber, model) {
+			continue
+		}
+		if itemRole, err := equipmentRole(item.Type); err == nil && itemRole == role {
+			return item
+		}
+	}
+
+	equipment := data_structures.Equipment{InputModelNumber: model, Brand: brand}
+	if r, exists := findEquipmentRole(role); exists {
+		equipment.Type = r.EquipmentType
+	}
+	return NormalizeString(equipment)
+}
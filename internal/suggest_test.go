@@ -0,0 +1,284 @@
+package internal
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"GSXN403010", "GSXN403010", 0},
+		{"GSXN403010", "GSXN403011", 1},
+		{"GSXN403010", "GSXN40301", 1},
+		{"CAPTA3026B4", "CHPTA3026B4", 1},
+		{"GSXN403010", "", 10},
+		{"ABC", "CBA", 2},
+	}
+
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCompareCombos(t *testing.T) {
+	key := func(outdoor, indoor, furnace string) data_structures.ComponentKey {
+		equip := func(model string) data_structures.Equipment {
+			return NormalizeString(data_structures.Equipment{InputModelNumber: model})
+		}
+		return data_structures.ComponentKey{OutdoorUnit: equip(outdoor), IndoorUnit: equip(indoor), Furnace: equip(furnace)}
+	}
+	plain := testAHRIIndex(t, nil, 0)
+	anyRevision, err := BuildAHRIIndex(nil, data_structures.AHRIIndexOptions{RevisionRules: []data_structures.RevisionRule{
+		{Component: ComponentOutdoorUnit, Prefix: "GSXN", Suffix: 2, AnyRevision: true},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		a, b         data_structures.ComponentKey
+		ahriIndex    *AHRIIndex
+		wantChanged  []string
+		wantDistance int
+	}{
+		{
+			name:        "identical",
+			a:           key("GSXN403010", "CAPTA3026B4", ""),
+			b:           key("GSXN403010", "CAPTA3026B4", ""),
+			ahriIndex:   plain,
+			wantChanged: []string{},
+		},
+		{
+			name:         "two components",
+			a:            key("GSXN403010", "CAPTA3026B4", "GR9S800803BN"),
+			b:            key("GSXN406010", "CHPTA3026B4", "GR9S800803BN"),
+			ahriIndex:    plain,
+			wantChanged:  []string{ComponentOutdoorUnit, ComponentIndoorUnit},
+			wantDistance: 2,
+		},
+		{
+			// Past the normalized length the models are the same
+			name:        "normalized models",
+			a:           key("GSXN403010", "CAPTA3026B4AA", ""),
+			b:           key("GSXN403010", "CAPTA3026B4BB", ""),
+			ahriIndex:   plain,
+			wantChanged: []string{},
+		},
+		{
+			name:        "any revision",
+			a:           key("GSXN403010AA", "CAPTA3026B4", ""),
+			b:           key("GSXN403010BB", "CAPTA3026B4", ""),
+			ahriIndex:   anyRevision,
+			wantChanged: []string{},
+		},
+		{
+			name:         "any revision compares the base",
+			a:            key("GSXN403010AA", "CAPTA3026B4", ""),
+			b:            key("GSXN406010BB", "CAPTA3026B4", ""),
+			ahriIndex:    anyRevision,
+			wantChanged:  []string{ComponentOutdoorUnit},
+			wantDistance: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed, distance := compareCombos(tt.a, tt.b, tt.ahriIndex)
+			if !slices.Equal(changed, tt.wantChanged) || distance != tt.wantDistance {
+				t.Errorf("compareCombos = %v, %d, want %v, %d", changed, distance, tt.wantChanged, tt.wantDistance)
+			}
+		})
+	}
+}
+
+func TestSuggestAlternatives(t *testing.T) {
+	list := engineTestEquipment()
+	ahriIndex := testAHRIIndex(t, engineTestRecords(), '%')
+	request := func(sysType, outdoor, indoor, furnace string) data_structures.ComponentKey {
+		t.Helper()
+		key, err := NewLookupRequest(list, "Goodman", sysType, outdoor, indoor, furnace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	describe := func(suggestions []data_structures.Suggestion) []string {
+		got := []string{}
+		for _, s := range suggestions {
+			got = append(got, s.Match.AHRINumber+" "+strings.Join(s.Changed, "+"))
+		}
+		return got
+	}
+
+	tests := []struct {
+		name      string
+		requested data_structures.ComponentKey
+		limit     int
+		want      []string
+	}{
+		{
+			// A horizontal coil: the upflow coil is one swap away, and ranks above
+			// also swapping the furnace for one certified by the wildcard record
+			name:      "single swap ranks first",
+			requested: request("central ac & furnace", "GSXN403010", "CHPTA3026B4", "GR9S800803BN"),
+			want:      []string{"1001 indoor unit", "1002 indoor unit+furnace", "1002 indoor unit+furnace"},
+		},
+		{
+			name:      "limit",
+			requested: request("central ac & furnace", "GSXN403010", "CHPTA3026B4", "GR9S800803BN"),
+			limit:     1,
+			want:      []string{"1001 indoor unit"},
+		},
+		{
+			// An unstocked outdoor unit is swapped for the stocked one it's closest to
+			name:      "unstocked outdoor unit",
+			requested: request("heat pump & air handler", "GSZB403011", "AMST30BU1300", ""),
+			want:      []string{"1005 outdoor unit"},
+		},
+		{
+			name:      "nothing close",
+			requested: request("heat pump & furnace", "XXXX", "YYYY", "ZZZZ"),
+			want:      []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suggestions, err := SuggestAlternatives(context.Background(), tt.requested, list, ahriIndex, nil, data_structures.MatchOptions{}, tt.limit)
+			if err != nil {
+				t.Fatalf("SuggestAlternatives: %v", err)
+			}
+			if got := describe(suggestions); !slices.Equal(got, tt.want) {
+				t.Errorf("suggestions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := SuggestAlternatives(context.Background(), data_structures.ComponentKey{SystemType: "furnace"}, list, ahriIndex, nil, data_structures.MatchOptions{}, 0); err == nil {
+		t.Error("suggestions for a system type that isn't AHRI certified")
+	}
+}
+
+func TestLookupCombination(t *testing.T) {
+	list := engineTestEquipment()
+	ahriIndex := testAHRIIndex(t, engineTestRecords(), '%')
+
+	certified, err := NewLookupRequest(list, "Goodman", "central ac & furnace", "GSXN403010", "CAPTA3026B4", "GR9S800803BN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup, err := LookupCombination(context.Background(), certified, list, nil, ahriIndex, nil, data_structures.MatchOptions{}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lookup.Records) == 0 || lookup.Records[0].AHRINumber != "1001" || len(lookup.Suggestions) != 0 {
+		t.Errorf("certified lookup = %+v, want AHRI 1001 and no suggestions", lookup)
+	}
+
+	uncertified, err := NewLookupRequest(list, "Goodman", "central ac & furnace", "GSXN403010", "CHPTA3026B4", "GR9S800803BN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup, err = LookupCombination(context.Background(), uncertified, list, nil, ahriIndex, nil, data_structures.MatchOptions{}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lookup.Records) != 0 || len(lookup.Suggestions) == 0 {
+		t.Errorf("uncertified lookup = %+v, want suggestions only", lookup)
+	}
+
+	// Alternatives come from the whole brand group
+	sisters := pipelineSisterBrands()
+	for _, tt := range []struct {
+		groups [][]string
+		want   bool
+	}{{nil, false}, {[][]string{{"Amana", "Goodman"}}, true}} {
+		lookup, err := LookupCombination(context.Background(), uncertified, sisters, tt.groups, ahriIndex, nil, data_structures.MatchOptions{}, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(lookup.Suggestions) > 0; got != tt.want {
+			t.Errorf("brand groups %v: suggestions %v, want %v", tt.groups, got, tt.want)
+		}
+	}
+}
+
+func TestNewLookupRequest(t *testing.T) {
+	list := engineTestEquipment()
+
+	tests := []struct {
+		name        string
+		brand       string
+		sysType     string
+		outdoor     string
+		wantBrand   string
+		wantStocked bool // the outdoor unit is the stocked equipment, not a stand in
+		wantErr     bool
+	}{
+		{name: "stocked", brand: "Goodman", sysType: "heat pump & furnace", outdoor: "GSZB403010", wantBrand: "Goodman", wantStocked: true},
+		{name: "brand and model in any case", brand: "GOODMAN", sysType: " Heat Pump & Furnace ", outdoor: "gszb403010", wantBrand: "Goodman", wantStocked: true},
+		{name: "unstocked model", brand: "goodman", sysType: "heat pump & furnace", outdoor: "GSZB409010", wantBrand: "Goodman"},
+		{name: "unknown brand", brand: "Trane", sysType: "heat pump & furnace", outdoor: "GSZB403010", wantBrand: "Trane"},
+		{name: "not certified system type", brand: "Goodman", sysType: "furnace", wantErr: true},
+		{name: "packaged unit", brand: "Goodman", sysType: "packaged ac", outdoor: "GPC1336H41", wantBrand: "Goodman"},
+		{name: "mini-split system type", brand: "Goodman", sysType: "mini split", outdoor: "MXO18", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := NewLookupRequest(list, tt.brand, tt.sysType, tt.outdoor, "CAPTA3026B4", "GR9S800803BN")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if request.Brand != tt.wantBrand {
+				t.Errorf("Brand = %q, want %q", request.Brand, tt.wantBrand)
+			}
+			if stocked := request.OutdoorUnit.Category != ""; stocked != tt.wantStocked {
+				t.Errorf("outdoor unit stocked = %v, want %v", stocked, tt.wantStocked)
+			}
+			system, _ := findSystemType(strings.ToLower(strings.TrimSpace(tt.sysType)))
+			if request.OutdoorUnit.NormalizedModelNumber == "" || (system.Furnace && request.Furnace.NormalizedModelNumber == "") {
+				t.Errorf("models not normalized: %+v", request)
+			}
+			if system.Indoor == "" && !system.Furnace && (request.Furnace != data_structures.Equipment{} || request.IndoorUnit != data_structures.Equipment{}) {
+				t.Errorf("components the system doesn't have are filled in: %+v", request)
+			}
+		})
+	}
+}
+
+func TestLoadSuggestionRequests(t *testing.T) {
+	list := engineTestEquipment()
+
+	filename := writeTestCSV(t, "Brand,System Type,Outdoor Unit,Indoor Unit,Furnace\n"+
+		"Goodman,central ac & furnace,GSXN403010,CAPTA3026B4,GR9S800803BN\n"+
+		"Goodman,heat pump & air handler,GSZB403010,AMST30BU1300,\n")
+	requests, err := LoadSuggestionRequests(filename, list)
+	if err != nil {
+		t.Fatalf("LoadSuggestionRequests: %v", err)
+	}
+	if len(requests) != 2 || requests[0].Furnace.InputModelNumber != "GR9S800803BN" || requests[1].SystemType != "air_source_heat_pump_electric_heat" {
+		t.Errorf("requests = %+v", requests)
+	}
+
+	bad := writeTestCSV(t, "Brand,System Type,Outdoor Unit,Indoor Unit,Furnace\n"+
+		"Goodman,central ac & furnace,GSXN403010,CAPTA3026B4,GR9S800803BN\n"+
+		"Goodman,boiler,GSXN403010,,\n")
+	if _, err := LoadSuggestionRequests(bad, list); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("err = %v, want an error naming line 3", err)
+	}
+}
@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+// SupersessionDateLayout is the format of the Effective Date column
+const SupersessionDateLayout = "2006-01-02"
+
+/*
+LoadSupersessions reads the model cross-reference from a csv file with the columns
+"Brand", "Old Model", "New Model" and "Effective Date" (YYYY-MM-DD). Brand and
+Effective Date may be left blank to apply to every brand and from any date.
+*/
+func LoadSupersessions(filename string) ([]data_structures.Supersession, error) {
+	headers, err := GetCSVHeader(filename, []string{"Brand", "Old Model", "New Model", "Effective Date"})
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error with opening %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+
+	if _, err := r.Read(); err != nil {
+		return nil, fmt.Errorf("error reading header: %w", err)
+	}
+
+	supersessions := []data_structures.Supersession{}
+	line := 1
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV: %w", err)
+		}
+		line++
+
+		field := func(name string) string {
+			idx := headers[name]
+			if idx >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[idx])
+		}
+
+		supersession := data_structures.Supersession{
+			Brand:    field("brand"),
+			OldModel: field("old model"),
+			NewModel: field("new model"),
+		}
+		if supersession.OldModel == "" || supersession.NewModel == "" {
+			return nil, fmt.Errorf("line %d: old and new model are required", line)
+		}
+
+		if date := field("effective date"); date != "" {
+			supersession.EffectiveDate, err = time.Parse(SupersessionDateLayout, date)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid effective date %q", line, date)
+			}
+		}
+
+		supersessions = append(supersessions, supersession)
+	}
+
+	return supersessions, nil
+}
+
+// buildSupersessions indexes the supersessions in effect at asOf by new model
+func buildSupersessions(supersessions []data_structures.Supersession, asOf time.Time) map[string][]data_structures.Supersession {
+	replaced := make(map[string][]data_structures.Supersession)
+	for _, supersession := range supersessions {
+		if supersession.EffectiveDate.After(asOf) {
+			continue
+		}
+		newModel := strings.ToUpper(supersession.NewModel)
+		replaced[newModel] = append(replaced[newModel], supersession)
+	}
+	return replaced
+}
+
+// predecessors returns the models the equipment replaced, directly or through a
+// chain of supersessions, as equipment of the same type and brand
+func (index *AHRIIndex) predecessors(equipment data_structures.Equipment) []data_structures.Equipment {
+	if len(index.replaced) == 0 || equipment.InputModelNumber == "" {
+		return nil
+	}
+
+	found := []data_structures.Equipment{}
+	seen := map[string]bool{strings.ToUpper(equipment.InputModelNumber): true}
+	queue := []string{strings.ToUpper(equipment.InputModelNumber)}
+
+	for len(queue) > 0 {
+		model := queue[0]
+		queue = queue[1:]
+
+		for _, supersession := range index.replaced[model] {
+			if supersession.Brand != "" && !strings.EqualFold(supersession.Brand, equipment.Brand) {
+				continue
+			}
+			oldModel := strings.ToUpper(supersession.OldModel)
+			if seen[oldModel] {
+				continue
+			}
+			seen[oldModel] = true
+			queue = append(queue, oldModel)
+
+			predecessor := equipment
+			predecessor.InputModelNumber = supersession.OldModel
+			found = append(found, NormalizeString(predecessor))
+		}
+	}
+
+	return found
+}
+
+/*
+lookupSuperseded looks the combination up with each component swapped for the
+models it replaced, for combinations whose current models aren't certified.
+Records are returned in the order found, each AHRI number once.
+*/
+func (index *AHRIIndex) lookupSuperseded(config data_structures.ComponentKey) ([]data_structures.AHRIRecord, bool) {
+	options := func(equipment data_structures.Equipment) []data_structures.Equipment {
+		return append([]data_structures.Equipment{equipment}, index.predecessors(equipment)...)
+	}
+
+	// The outdoor unit, then each indoor unit or head, then the furnace
+	components := append([]data_structures.Equipment{config.OutdoorUnit}, indoorUnits(config)...)
+	components = append(components, config.Furnace)
+
+	choices := make([][]data_structures.Equipment, len(components))
+	replaceable := false
+	for i, component := range components {
+		choices[i] = options(component)
+		replaceable = replaceable || len(choices[i]) > 1
+	}
+	if !replaceable {
+		return nil, false
+	}
+
+	matched := []data_structures.AHRIRecord{}
+	seen := make(map[string]bool)
+
+	picked := make([]data_structures.Equipment, len(components))
+	var pick func(i int)
+	pick = func(i int) {
+		if i < len(choices) {
+			for _, choice := range choices[i] {
+				picked[i] = choice
+				pick(i + 1)
+			}
+			return
+		}
+
+		swapped := config
+		swapped.OutdoorUnit = picked[0]
+		swapped.Furnace = picked[len(picked)-1]
+		if len(config.IndoorHeads) > 0 {
+			swapped.IndoorHeads = picked[1 : len(picked)-1]
+		} else {
+			swapped.IndoorUnit = picked[1]
+		}
+
+		records, _ := index.Lookup(config.Brand, config.SystemType, index.comboKeys(swapped)...)
+		for _, record := range records {
+			if !seen[record.AHRINumber] {
+				seen[record.AHRINumber] = true
+				matched = append(matched, record)
+			}
+		}
+	}
+	pick(0)
+
+	return matched, len(matched) > 0
+}
+
+// supersededComponents lists "old -> new" for each component of combo that record
+// only certifies through the model it replaced
+func (index *AHRIIndex) supersededComponents(combo data_structures.ComponentKey, record data_structures.AHRIRecord) []string {
+	superseded := []string{}
+	if len(index.replaced) == 0 {
+		return superseded
+	}
+
+	for _, c := range index.componentPairs(combo, record) {
+		rated := index.modelKey(c.name, NormalizeString(c.rated))
+		if index.matchModel(rated, index.modelKey(c.name, c.stocked)) {
+			continue
+		}
+		for _, predecessor := range index.predecessors(c.stocked) {
+			if index.matchModel(rated, index.modelKey(c.name, predecessor)) {
+				superseded = append(superseded, predecessor.InputModelNumber+" -> "+c.stocked.InputModelNumber)
+				break
+			}
+		}
+	}
+
+	return superseded
+}
@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+func TestLoadSupersessions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []data_structures.Supersession
+		wantErr string
+	}{
+		{
+			name:    "blank brand and date",
+			content: "Brand,Old Model,New Model,Effective Date\nGoodman,GSXM403010,GSXN403010,2025-01-01\n,CAPT3026B4,CAPTA3026B4,\n",
+			want: []data_structures.Supersession{
+				{Brand: "Goodman", OldModel: "GSXM403010", NewModel: "GSXN403010", EffectiveDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+				{OldModel: "CAPT3026B4", NewModel: "CAPTA3026B4"},
+			},
+		},
+		{name: "missing model", content: "Brand,Old Model,New Model,Effective Date\nGoodman,,GSXN403010,\n", wantErr: "line 2"},
+		{name: "bad date", content: "Brand,Old Model,New Model,Effective Date\nGoodman,GSXM403010,GSXN403010,01/01/2025\n", wantErr: "invalid effective date"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			supersessions, err := LoadSupersessions(writeTestCSV(t, tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadSupersessions: %v", err)
+			}
+			if !reflect.DeepEqual(supersessions, tt.want) {
+				t.Errorf("supersessions = %+v, want %+v", supersessions, tt.want)
+			}
+		})
+	}
+}
+
+func TestPredecessors(t *testing.T) {
+	asOf := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	supersessions := []data_structures.Supersession{
+		{OldModel: "GSXL403010", NewModel: "GSXM403010"},
+		{OldModel: "GSXM403010", NewModel: "gsxn403010"},
+		{OldModel: "GSXN403010", NewModel: "GSXL403010"}, // a cycle back to the start
+		{Brand: "Amana", OldModel: "ASXM403010", NewModel: "GSXN403010"},
+		{OldModel: "GSXP403010", NewModel: "GSXN403010", EffectiveDate: asOf.AddDate(0, 1, 0)},
+	}
+	index, err := BuildAHRIIndex(nil, data_structures.AHRIIndexOptions{Supersessions: supersessions, AsOf: asOf})
+	if err != nil {
+		t.Fatalf("BuildAHRIIndex: %v", err)
+	}
+
+	tests := []struct {
+		brand string
+		model string
+		want  []string
+	}{
+		{brand: "Goodman", model: "GSXN403010", want: []string{"GSXM403010", "GSXL403010"}},
+		{brand: "amana", model: "GSXN403010", want: []string{"GSXM403010", "ASXM403010", "GSXL403010"}},
+		{brand: "Goodman", model: "GSXM403010", want: []string{"GSXL403010", "GSXN403010"}},
+		{brand: "Goodman", model: "GSXN406010", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		equipment := NormalizeString(data_structures.Equipment{InputModelNumber: tt.model, Brand: tt.brand, Type: "outdoor unit (ac)"})
+		got := []string{}
+		for _, predecessor := range index.predecessors(equipment) {
+			if predecessor.Brand != tt.brand || predecessor.Type != equipment.Type || predecessor.NormalizedModelNumber == "" {
+				t.Errorf("predecessor %+v doesn't carry over the brand, type and normalized model", predecessor)
+			}
+			got = append(got, predecessor.InputModelNumber)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s %s predecessors = %v, want %v", tt.brand, tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestSupersededCertification(t *testing.T) {
+	list := []data_structures.Equipment{}
+	for _, e := range []struct{ equipType, model string }{
+		{"outdoor unit (hp)", "GSZB403010"},
+		{"evaporator coil", "CAPTA3026B4"},
+		{"furnace", "GR9S800803BN"},
+	} {
+		item := NormalizeString(data_structures.Equipment{InputModelNumber: e.model, Brand: "Goodman", Type: e.equipType})
+		list = append(list, AssignOrientation(CategorizeEquipment(item, DefaultCategoryRules()), DefaultOrientationRules()))
+	}
+
+	// AHRI still lists the coil and furnace our stock replaced
+	records := []data_structures.AHRIRecord{{
+		AHRINumber:  "1001",
+		OutdoorUnit: data_structures.Equipment{InputModelNumber: "GSZB403010"},
+		IndoorUnit:  data_structures.Equipment{InputModelNumber: "CAPT3026B4"},
+		Furnace:     data_structures.Equipment{InputModelNumber: "GR9S800803AN"},
+	}}
+	supersessions := []data_structures.Supersession{
+		{OldModel: "CAPT3026B4", NewModel: "CAPTA3026B4"},
+		{OldModel: "GR9S800803AN", NewModel: "GR9S800803BN"},
+	}
+
+	tests := []struct {
+		name          string
+		supersessions []data_structures.Supersession
+		want          string // SupersededModels of the match, "-" for no match
+	}{
+		{name: "no cross reference", want: "-"},
+		{name: "one replacement", supersessions: supersessions[:1], want: "-"},
+		{name: "both replacements", supersessions: supersessions, want: "CAPT3026B4 -> CAPTA3026B4; GR9S800803AN -> GR9S800803BN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, err := BuildAHRIIndex(records, data_structures.AHRIIndexOptions{Supersessions: tt.supersessions})
+			if err != nil {
+				t.Fatalf("BuildAHRIIndex: %v", err)
+			}
+
+			for _, engine := range []string{EngineCartesian, EngineCertification} {
+				job := data_structures.MatchJob{Brand: "Goodman", SystemType: "heat pump & furnace", Equipment: list}
+				result := runMatchJob(context.Background(), job, engine, index, nil, data_structures.MatchOptions{})
+				if result.Err != nil {
+					t.Fatalf("%s: %v", engine, result.Err)
+				}
+
+				got := "-"
+				if len(result.Matches) == 1 && result.Matches[0].AHRINumber == "1001" {
+					got = result.Matches[0].SupersededModels
+				} else if len(result.Matches) > 1 {
+					t.Fatalf("%s: matches = %+v", engine, result.Matches)
+				}
+				if got != tt.want {
+					t.Errorf("%s: superseded models = %q, want %q", engine, got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+/*
+SystemType describes one kind of system the matcher builds and certifies: which
+equipment roles make it up, which filters a combination must pass, how it is looked
+up in the AHRI index and how it fills the output row. Every system type the parser
+knows is registered in one place (see builtinSystemTypes and RegisterSystemType).
+*/
+type SystemType struct {
+	Name  string // name used in configuration, e.g. "heat pump & furnace"
+	Value string // value reported in the output, e.g. "air_source_heat_pump_furnace"
+
+	// Component roles (see EquipmentRole): the outdoor unit (e.g. "ac", "hp" or ""
+	// for none), the indoor unit (e.g. "coil", "handler" or "") and whether the
+	// system includes a furnace
+	Outdoor string
+	Indoor  string
+	Furnace bool
+
+	// Mini-split systems have MinHeads to MaxHeads indoor heads of the Heads roles
+	// (head roles, e.g. "wall") in place of a single indoor unit
+	Heads    []string
+	MinHeads int
+	MaxHeads int
+
+	// Filters a combination must pass, in order, before it is looked up
+	Filters []ComboFilter
+
+	// Certified systems are looked up in the AHRI index; the rest are reported on
+	// their filters alone
+	Certified bool
+
+	// Lookup replaces FindAHRICertification for finding the records certifying a
+	// combination. The certification engine only generates certified systems that
+	// use the default lookup; others fall back to the Cartesian generator.
+	Lookup func(combo data_structures.ComponentKey, ahriIndex *AHRIIndex) ([]data_structures.AHRIRecord, bool)
+
+	// UncertifiedValue, when set, makes the lookup optional: it only runs when the
+	// system type is listed in MatchOptions.VerifySystemTypes, and combinations it
+	// can't certify are reported under this value instead of being dropped
+	UncertifiedValue string
+
+	// FillOutput puts the combination's models in the output row. Nil fills each
+	// component's model in its role's output field.
+	FillOutput func(output *data_structures.OutputCSV, combo data_structures.ComponentKey)
+}
+
+// ComboFilter is a check a combination must pass. Name is the filter counted in
+// MatchOptions.Rejections when it fails.
+type ComboFilter struct {
+	Name  string
+	Check func(combo data_structures.ComponentKey) bool
+}
+
+// The filters used by the built in system types
+var (
+	filterIndoorUnit = ComboFilter{Name: RejectIndoorUnit, Check: func(combo data_structures.ComponentKey) bool {
+		return isValidIndoorUnit(combo.IndoorUnit)
+	}}
+	filterTonnage = ComboFilter{Name: RejectTonnage, Check: func(combo data_structures.ComponentKey) bool {
+		return isValidTonnageMatch(combo.OutdoorUnit, combo.IndoorUnit)
+	}}
+	filterCabinetAndTonnage = ComboFilter{Name: RejectCabinetAndTonnage, Check: isValidCabinetAndTonnage}
+)
+
+// headRoles are the roles of the built in mini-split indoor heads: ductless wall
+// units, ceiling cassettes and ducted slim units
+var headRoles = []string{"wall", "cassette", "slim"}
+
+// builtinSystemTypes returns the system types the parser ships with, in the order they are matched
+func builtinSystemTypes() []SystemType {
+	return []SystemType{
+		{
+			Name: "central ac", Value: "central_ac",
+			Outdoor: "ac", Indoor: "coil",
+			Filters:          []ComboFilter{filterIndoorUnit, filterTonnage},
+			Certified:        true,
+			Lookup:           findCentralACCertification,
+			UncertifiedValue: "central_ac_tonnage_compatible_not_certified",
+		},
+		{
+			Name: "furnace", Value: "furnace",
+			Furnace: true,
+		},
+		{
+			Name: "central ac & air handler", Value: "central_ac_air_handler",
+			Outdoor: "ac", Indoor: "handler",
+			Filters:   []ComboFilter{filterIndoorUnit},
+			Certified: true,
+		},
+		{
+			Name: "central ac & furnace", Value: "central_ac_furnace",
+			Outdoor: "ac", Indoor: "coil", Furnace: true,
+			Filters:   []ComboFilter{filterIndoorUnit, filterCabinetAndTonnage},
+			Certified: true,
+		},
+		{
+			Name: "heat pump & air handler", Value: "air_source_heat_pump_electric_heat",
+			Outdoor: "hp", Indoor: "handler",
+			Filters:   []ComboFilter{filterIndoorUnit},
+			Certified: true,
+		},
+		{
+			Name: "heat pump & furnace", Value: "air_source_heat_pump_furnace",
+			Outdoor: "hp", Indoor: "coil", Furnace: true,
+			Filters:   []ComboFilter{filterIndoorUnit, filterCabinetAndTonnage},
+			Certified: true,
+		},
+		{
+			Name: "heat pump", Value: "air_source_heat_pump",
+			Outdoor: "hp", Indoor: "coil",
+			Filters:   []ComboFilter{filterIndoorUnit, filterTonnage},
+			Certified: true,
+		},
+		{
+			Name: "mini split", Value: "ductless_mini_split",
+			Outdoor: "mini split", Heads: headRoles, MinHeads: 1, MaxHeads: 1,
+			Certified: true,
+		},
+		{
+			Name: "multi-zone mini split", Value: "ductless_multi_zone",
+			Outdoor: "mini split", Heads: headRoles, MinHeads: 2, MaxHeads: MaxIndoorHeads,
+			Certified: true,
+		},
+		{
+			Name: "packaged ac", Value: "packaged_ac",
+			Outdoor: "packaged ac", Certified: true,
+		},
+		{
+			Name: "packaged heat pump", Value: "packaged_heat_pump",
+			Outdoor: "packaged hp", Certified: true,
+		},
+		{
+			Name: "packaged gas/electric", Value: "packaged_gas_electric",
+			Outdoor: "packaged gas", Certified: true,
+		},
+		{
+			Name: "geothermal heat pump", Value: "geothermal_heat_pump",
+			Outdoor: waterToAirRole, Certified: true,
+		},
+		{
+			Name: "geothermal heat pump & air handler", Value: "geothermal_heat_pump_air_handler",
+			Outdoor: waterToAirRole, Indoor: "handler",
+			Certified: true,
+		},
+	}
+}
+
+// systemTypeRegistry holds every registered system type in registration order
+var systemTypeRegistry []SystemType
+
+func init() {
+	// Set here rather than in the declaration: the built in lookups refer back to the
+	// registries. Roles come first since system types are built from them.
+	roleRegistry = builtinEquipmentRoles()
+	systemTypeRegistry = builtinSystemTypes()
+}
+
+/*
+RegisterSystemType adds a system type so it can be matched by name like the built
+in ones. Names and values must be unique and the roles must be registered equipment
+roles that fill the right component (see RegisterEquipmentRole). Register types
+before any matching starts; the registry isn't safe to change while jobs are running.
+*/
+func RegisterSystemType(sysType SystemType) error {
+	sysType.Name = strings.ToLower(strings.TrimSpace(sysType.Name))
+	if sysType.Name == "" || sysType.Value == "" {
+		return fmt.Errorf("system type needs a name and a value")
+	}
+
+	for _, existing := range systemTypeRegistry {
+		if existing.Name == sysType.Name || existing.Value == sysType.Value {
+			return fmt.Errorf("system type %s is already registered", sysType.Name)
+		}
+	}
+
+	// checkRole reports a role that isn't registered or doesn't fill the component
+	checkRole := func(kind string, name string, component string, head bool) error {
+		if name == "" {
+			return nil
+		}
+		role, exists := findEquipmentRole(name)
+		if !exists || role.Component != component || role.Head != head {
+			return fmt.Errorf("system type %s: unknown %s role %q", sysType.Name, kind, name)
+		}
+		return nil
+	}
+	if err := checkRole("outdoor", sysType.Outdoor, ComponentOutdoorUnit, false); err != nil {
+		return err
+	}
+	if err := checkRole("indoor", sysType.Indoor, ComponentIndoorUnit, false); err != nil {
+		return err
+	}
+	for _, role := range sysType.Heads {
+		if err := checkRole("indoor head", role, ComponentIndoorUnit, true); err != nil {
+			return err
+		}
+	}
+	if len(sysType.Heads) > 0 {
+		if sysType.Indoor != "" || sysType.Furnace {
+			return fmt.Errorf("system type %s can't have both indoor heads and an indoor unit or furnace", sysType.Name)
+		}
+		if sysType.MinHeads < 1 || sysType.MaxHeads < sysType.MinHeads || sysType.MaxHeads > MaxIndoorHeads {
+			return fmt.Errorf("system type %s: heads must be between 1 and %d", sysType.Name, MaxIndoorHeads)
+		}
+	}
+	if sysType.Outdoor == "" && sysType.Indoor == "" && len(sysType.Heads) == 0 && !sysType.Furnace {
+		return fmt.Errorf("system type %s has no components", sysType.Name)
+	}
+	if sysType.UncertifiedValue != "" && !sysType.Certified {
+		return fmt.Errorf("system type %s reports uncertified systems but isn't certified", sysType.Name)
+	}
+	if sysType.Lookup != nil && !sysType.Certified {
+		return fmt.Errorf("system type %s has a lookup but isn't certified", sysType.Name)
+	}
+
+	systemTypeRegistry = append(systemTypeRegistry, sysType)
+	return nil
+}
+
+// SystemTypeNames returns the names of every registered system type, in registration order.
+func SystemTypeNames() []string {
+	names := make([]string, 0, len(systemTypeRegistry))
+	for _, sysType := range systemTypeRegistry {
+		names = append(names, sysType.Name)
+	}
+	return names
+}
+
+// findSystemType returns the registered system type with the name
+func findSystemType(name string) (*SystemType, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i := range systemTypeRegistry {
+		if systemTypeRegistry[i].Name == name {
+			return &systemTypeRegistry[i], true
+		}
+	}
+	return nil, false
+}
+
+// systemTypeByValue returns the registered system type reported as value
+func systemTypeByValue(value string) (*SystemType, bool) {
+	for i := range systemTypeRegistry {
+		if systemTypeRegistry[i].Value == value {
+			return &systemTypeRegistry[i], true
+		}
+	}
+	return nil, false
+}
+
+// systemTypeValue returns the output value of a registered system type name, or "" if unknown
+func systemTypeValue(name string) string {
+	if sysType, exists := findSystemType(name); exists {
+		return sysType.Value
+	}
+	return ""
+}
+
+// ahriDriven reports whether every combination of the system type must be AHRI
+// certified, so the certification engine can generate it from the AHRI index
+func (sysType *SystemType) ahriDriven() bool {
+	return sysType.Certified && sysType.Lookup == nil && sysType.UncertifiedValue == "" && sysType.Outdoor != ""
+}
+
+// certificationGenerated reports whether the system type's combinations are generated
+// from the AHRI index whichever engine is chosen. A multi-zone outdoor unit takes any
+// set of up to MaxHeads heads, repeats allowed: 60 stocked heads make over 7 million
+// sets per outdoor unit, so only the certified sets are ever built.
+func (sysType *SystemType) certificationGenerated() bool {
+	return sysType.MaxHeads > 1 && sysType.ahriDriven()
+}
+
+// lookup finds the AHRI records certifying a combination of the system type
+func (sysType *SystemType) lookup(combo data_structures.ComponentKey, ahriIndex *AHRIIndex) ([]data_structures.AHRIRecord, bool) {
+	if sysType.Lookup != nil {
+		return sysType.Lookup(combo, ahriIndex)
+	}
+	return FindAHRICertification(combo, ahriIndex)
+}
+
+// fillOutput puts the combination's models in the output columns for its roles
+func (sysType *SystemType) fillOutput(output *data_structures.OutputCSV, combo data_structures.ComponentKey) {
+	if sysType.FillOutput != nil {
+		sysType.FillOutput(output, combo)
+		return
+	}
+
+	fill := func(name string, equipment data_structures.Equipment) {
+		if role, exists := findEquipmentRole(name); exists {
+			role.Fill(output, equipment.InputModelNumber)
+		}
+	}
+
+	if sysType.Outdoor != "" {
+		fill(sysType.Outdoor, combo.OutdoorUnit)
+	}
+	if sysType.Indoor != "" {
+		fill(sysType.Indoor, combo.IndoorUnit)
+	}
+	if sysType.Furnace {
+		fill("furnace", combo.Furnace)
+	}
+	// Each head is reported by its own role
+	for _, head := range combo.IndoorHeads {
+		if name, err := equipmentRole(head.Type); err == nil {
+			fill(name, head)
+		}
+	}
+}
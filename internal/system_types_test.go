@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
+)
+
+func TestRegisterSystemType(t *testing.T) {
+	tests := []struct {
+		name    string
+		sysType SystemType
+		wantErr bool
+	}{
+		{name: "new type", sysType: SystemType{Name: "Heat Pump & Coil Only", Value: "hp_coil", Outdoor: "hp", Indoor: "coil", Certified: true}},
+		{name: "no value", sysType: SystemType{Name: "heat pump only", Outdoor: "hp"}, wantErr: true},
+		{name: "name taken", sysType: SystemType{Name: "heat pump", Value: "hp_only", Outdoor: "hp"}, wantErr: true},
+		{name: "value taken", sysType: SystemType{Name: "heat pump only", Value: "air_source_heat_pump", Outdoor: "hp"}, wantErr: true},
+		{name: "unknown role", sysType: SystemType{Name: "boiler", Value: "boiler", Outdoor: "boiler"}, wantErr: true},
+		{name: "role fills another component", sysType: SystemType{Name: "coil only", Value: "coil_only", Outdoor: "coil"}, wantErr: true},
+		{name: "no components", sysType: SystemType{Name: "nothing", Value: "nothing"}, wantErr: true},
+		{name: "heads", sysType: SystemType{Name: "dual zone", Value: "dual_zone", Outdoor: "mini split", Heads: []string{"wall"}, MinHeads: 2, MaxHeads: 2, Certified: true}},
+		{name: "head role that isn't a head", sysType: SystemType{Name: "coil zone", Value: "coil_zone", Outdoor: "mini split", Heads: []string{"coil"}, MinHeads: 1, MaxHeads: 1}, wantErr: true},
+		{name: "indoor role that is a head", sysType: SystemType{Name: "wall only", Value: "wall_only", Outdoor: "mini split", Indoor: "wall"}, wantErr: true},
+		{name: "heads and an indoor unit", sysType: SystemType{Name: "mixed", Value: "mixed", Outdoor: "mini split", Indoor: "coil", Heads: []string{"wall"}, MinHeads: 1, MaxHeads: 1}, wantErr: true},
+		{name: "too many heads", sysType: SystemType{Name: "big zone", Value: "big_zone", Outdoor: "mini split", Heads: []string{"wall"}, MinHeads: 1, MaxHeads: MaxIndoorHeads + 1}, wantErr: true},
+		{name: "uncertified value of an uncertified type", sysType: SystemType{Name: "ac only", Value: "ac_only", Outdoor: "ac", UncertifiedValue: "ac_only_unverified"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restoreRegistries(t)
+
+			err := RegisterSystemType(tt.sysType)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if names := SystemTypeNames(); err == nil && names[len(names)-1] != strings.ToLower(tt.sysType.Name) {
+				t.Errorf("SystemTypeNames() = %v, want the registered type last", names)
+			}
+		})
+	}
+}
+
+func TestRegisteredSystemTypeMatches(t *testing.T) {
+	restoreRegistries(t)
+
+	if err := RegisterSystemType(SystemType{
+		Name: "ac & any coil", Value: "ac_any_coil",
+		Outdoor: "ac", Indoor: "coil",
+		Filters: []ComboFilter{filterTonnage},
+	}); err != nil {
+		t.Fatalf("RegisterSystemType: %v", err)
+	}
+
+	combos, err := GenerateFullSystemEquipmentConfig(context.Background(), engineTestEquipment(), "ac & any coil", nil)
+	if err != nil {
+		t.Fatalf("GenerateFullSystemEquipmentConfig: %v", err)
+	}
+	matches, err := FindCertifiedMatches(context.Background(), slices.Values(combos), testAHRIIndex(t, nil, 0), data_structures.MatchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Not certified, so every combination passing the tonnage filter is reported with its roles filled
+	got := []string{}
+	for _, m := range matches {
+		if m.TypeOfSystem != "ac_any_coil" || m.MatchMethod != MatchHeuristic || m.Furnace != "" {
+			t.Errorf("unexpected match %+v", m)
+		}
+		got = append(got, m.OutdoorUnit+" + "+m.EvaporatorCoil)
+	}
+	want := []string{"GSXN403010 + CAPTA3026B4", "GSXN403010 + CHPTA3026B4", "GXV603010 + CAPEA3026B4"}
+	if !slices.Equal(got, want) {
+		t.Errorf("matches = %v, want %v", got, want)
+	}
+}
+
+func TestPackagedMatches(t *testing.T) {
+	list := engineTestEquipment()
+	for _, s := range []struct{ equipType, model string }{
+		{data_structures.TypePackagedAC, "GPC1336H41"},
+		{data_structures.TypePackagedAC, "GPC1348H41"},
+		{data_structures.TypePackagedHeatPump, "GPH1336H41"},
+		{data_structures.TypePackagedGasElectric, "GPG1336070"},
+	} {
+		item := NormalizeString(data_structures.Equipment{InputModelNumber: s.model, Brand: "Goodman", Type: s.equipType})
+		list = append(list, CategorizeEquipment(item, DefaultCategoryRules()))
+	}
+
+	record := func(number, model string) data_structures.AHRIRecord {
+		return data_structures.AHRIRecord{AHRINumber: number, OutdoorUnit: data_structures.Equipment{InputModelNumber: model}}
+	}
+	records := append(engineTestRecords(),
+		record("3001", "GPC1336H41"),
+		record("3002", "GPH13*6H41"),
+		record("3003", "GPG1336070"),
+		// Listed with a coil, so not a single-package certification
+		data_structures.AHRIRecord{AHRINumber: "3004", OutdoorUnit: data_structures.Equipment{InputModelNumber: "GPC1348H41"}, IndoorUnit: data_structures.Equipment{InputModelNumber: "CAPTA3026B4"}},
+	)
+	ahriIndex := testAHRIIndex(t, records, 0)
+
+	tests := []struct {
+		sysType string
+		want    []string // AHRI number and packaged unit of each match
+	}{
+		{"packaged ac", []string{"3001 GPC1336H41"}},
+		{"packaged heat pump", []string{"3002 GPH1336H41"}},
+		{"packaged gas/electric", []string{"3003 GPG1336070"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sysType, func(t *testing.T) {
+			cartesian, err := GenerateFullSystemEquipmentConfig(context.Background(), list, tt.sysType, nil)
+			if err != nil {
+				t.Fatalf("cartesian engine: %v", err)
+			}
+			certified, err := GenerateCertifiedSystemEquipmentConfig(context.Background(), list, tt.sysType, ahriIndex, nil, nil)
+			if err != nil {
+				t.Fatalf("certification engine: %v", err)
+			}
+
+			want, err := FindCertifiedMatches(context.Background(), slices.Values(cartesian), ahriIndex, data_structures.MatchOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := FindCertifiedMatches(context.Background(), slices.Values(certified), ahriIndex, data_structures.MatchOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("certification engine matches differ\ngot:  %+v\nwant: %+v", got, want)
+			}
+
+			// The unit is reported in its own column, not as an outdoor unit
+			matched := []string{}
+			for _, match := range want {
+				if match.OutdoorUnit != "" || match.EvaporatorCoil != "" || match.Furnace != "" {
+					t.Errorf("match %+v reports components a packaged system doesn't have", match)
+				}
+				matched = append(matched, match.AHRINumber+" "+match.PackagedUnit)
+			}
+			if !slices.Equal(matched, tt.want) {
+				t.Errorf("matches = %v, want %v", matched, tt.want)
+			}
+		})
+	}
+
+	if got := RoleColumns(list); !reflect.DeepEqual(got, PackagedUnitColumns()) {
+		t.Errorf("RoleColumns = %+v, want the packaged unit column once", got)
+	}
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/datsun80zx/hvac_match_parser/internal"
 	"github.com/datsun80zx/hvac_match_parser/internal/data_structures"
@@ -14,6 +19,130 @@ func main() {
 	csvFileEquip := "C:/Users/mrich/dev_work/hvac_match_parser/data/wilson_equip_list.csv"
 	csvFileAHRI := "C:/Users/mrich/dev_work/hvac_match_parser/data/ahri_matches.csv"
 
+	// '*' in AHRI model numbers always matches exactly one character. Set this to the
+	// character a manufacturer uses for "any number of characters" (0 to disable).
+	var multiCharWildcard byte = 0
+
+	// When the AHRI export has manufacturer and program columns, records only certify their
+	// own brand and system types. Programs named after a system type (e.g. "heat pump & furnace")
+	// are understood as is; map any other AHRI program to the system types it covers, e.g.
+	// "HRCU-A-CB": {"heat pump & furnace", "heat pump & air handler", "heat pump"}.
+	ahriPrograms := map[string][]string{}
+
+	// AHRI manufacturer names are matched to equipment list brands ignoring case. Map any
+	// manufacturer spelled differently to the brands it certifies, e.g.
+	// "Goodman Manufacturing": {"Goodman", "Wilson"}.
+	manufacturerAliases := map[string][]string{}
+
+	// When several AHRI references certify the same combination: "rows" writes one row per
+	// reference, "combined" writes one row listing the others, "primary" keeps only the primary.
+	// The primary reference is chosen by "first", "lowest number" or "highest rating".
+	ahriOutput := "rows"
+	primaryAHRI := "first"
+
+	// System types whose AHRI lookup is optional are reported whenever they pass their filters,
+	// with no AHRI number, unless listed here. Listing "central ac" (condenser + coil) looks each
+	// pairing up in the AHRI index and reports the rest as "central_ac_tonnage_compatible_not_certified".
+	verifySystemTypes := []string{}
+
+	// How combinations are generated: "cartesian" builds every outdoor x indoor x furnace
+	// product and looks each one up; "certification" walks the AHRI index and keeps only
+	// combinations we stock, which scales far better on large catalogs. The matches are
+	// identical; combinations_checked and the rejection counts in the run stats are not,
+	// since the certification engine never generates combinations that can't be certified.
+	matchEngine := internal.EngineCartesian
+
+	// Brands and system types are matched on a pool of workers. Output is identical to a
+	// sequential run. A timeout of 0 lets matching run for as long as it needs.
+	matchWorkers := runtime.NumCPU()
+	matchTimeout := 0 * time.Minute
+
+	// Sister brands (e.g. brands built in the same factory) whose equipment may be paired
+	// with each other, e.g. {"Goodman", "Amana", "Daikin"}. Ungrouped brands only pair
+	// within themselves. With any groups the default layout gains per component brand columns.
+	brandGroups := [][]string{}
+
+	// Loop conditions whose EER and COP are reported for geothermal and water-source heat
+	// pumps: "ground loop" (closed loop), "ground water" (open loop) and/or "water loop"
+	geothermalLoops := []string{"ground loop"}
+
+	// Optional customer specific output layout (.csv column list or text/template file).
+	// Leave empty to use the default column layout (see internal.DefaultOutputLayout).
+	outputLayoutFile := ""
+
+	// Optional equipment category rules (.csv with Type, Contains, Category columns), first
+	// match wins. Leave empty to split equipment into standard and communicating only.
+	categoryRulesFile := ""
+
+	// Optional category pairing matrix (.csv with Outdoor Category, Indoor Category columns)
+	// listing categories that may be combined besides a category with itself, e.g. a
+	// variable-speed outdoor unit with a two-stage coil. Leave empty to pair within categories only.
+	categoryPairingsFile := ""
+
+	// Optional orientation rules (.csv with Type, Position, Code, Orientation columns).
+	// Leave empty to use the built in model position rules.
+	orientationRulesFile := ""
+
+	// Optional model revision policy (.csv with Component, Prefix, Suffix, Any Revision columns).
+	// Covered models are compared in full, or without their revision suffix when any revision
+	// satisfies a certification, instead of being truncated. Leave empty to truncate every model.
+	revisionRulesFile := ""
+
+	// Optional model cross-reference (.csv with Brand, Old Model, New Model, Effective Date
+	// columns) so AHRI certifications still listed under a replaced model apply to its
+	// replacement. Leave empty to match models only as listed.
+	supersessionsFile := ""
+
+	// Only report systems whose components have one of these orientations (empty = all)
+	allowedOrientations := []string{}
+
+	// Output row order: any of "brand", "system type", "outdoor unit", "ahri number", "rating"
+	outputSortKeys := []string{"brand", "system type", "outdoor unit", "ahri number"}
+
+	// Split the output into one file per brand and/or system type inside outputDirectory.
+	// With both disabled a single file is written to outputFilename.
+	splitByBrand := false
+	splitBySystemType := false
+	outputDirectory := "C:/Users/mrich/OneDrive/Wilson/wilson_hvac_matches"
+	outputFilename := outputDirectory + "/certified_hvac_matches.csv"
+
+	// Also write every match with its provenance (match method, wildcard positions,
+	// confidence and AHRI source row) as JSON. Leave empty to skip.
+	jsonOutputFilename := ""
+
+	// Stream matches straight to outputFilename as they are found instead of collecting
+	// them first. Memory stays flat on huge catalogs, but jobs run one at a time, rows are
+	// written in match order (outputSortKeys is ignored) and split output, the matrix and
+	// template layouts are unavailable.
+	streamOutput := false
+
+	// Also write an outdoor x indoor compatibility matrix per brand and system type
+	writeMatrix := false
+	matrixDirectory := outputDirectory + "/matrix"
+
+	// Optional csv of requested systems (Brand, System Type, Outdoor Unit, Indoor Unit, Furnace).
+	// For each one that isn't certified, the closest certified alternatives we stock are listed.
+	suggestionRequestsFile := ""
+	suggestionLimit := 5
+
+	// "hvac_match_parser lookup -brand B -type T -outdoor M [-indoor M] [-furnace M]" looks
+	// up a single system instead of running the match: it prints the AHRI references
+	// certifying it or, when there are none, the closest certified alternatives we stock.
+	var lookupRequest *lookupArgs
+	if len(os.Args) > 1 && os.Args[1] == "lookup" {
+		args, err := parseLookupArgs(os.Args[2:])
+		if err != nil {
+			log.Fatalf("lookup: %v", err)
+		}
+		lookupRequest = &args
+	}
+
+	// Machine readable run statistics (JSON). Leave empty to skip.
+	statsFilename := outputDirectory + "/run_stats.json"
+
+	runStart := time.Now()
+	stats := internal.NewRunStats(runStart)
+
 	// Define what column headers we are expecting to see in the equipment list csv:
 
 	equipmentFields := []string{
@@ -25,6 +154,103 @@ func main() {
 		"Air Handler",
 	}
 
+	outputLayout := internal.DefaultOutputLayout()
+	if outputLayoutFile != "" {
+		layout, err := internal.LoadOutputLayout(outputLayoutFile)
+		if err != nil {
+			log.Fatalf("Failed to load output layout: %v", err)
+		}
+		outputLayout = layout
+		fmt.Printf("Using output layout from %s\n\n", outputLayoutFile)
+	} else if len(brandGroups) > 0 {
+		outputLayout.Columns = append(outputLayout.Columns, internal.ComponentBrandColumns()...)
+	}
+
+	if matchEngine != internal.EngineCartesian && matchEngine != internal.EngineCertification {
+		log.Fatalf("Unknown match engine: %s", matchEngine)
+	}
+
+	if streamOutput && (splitByBrand || splitBySystemType || writeMatrix || jsonOutputFilename != "" || outputLayout.Template != "") {
+		log.Fatalf("Streaming output needs a single column layout file with no split, matrix or JSON output")
+	}
+
+	if err := internal.SortMatches(nil, outputSortKeys); err != nil {
+		log.Fatalf("Invalid output sort keys: %v", err)
+	}
+
+	waterLoopColumns, err := internal.WaterLoopColumns(geothermalLoops)
+	if err != nil {
+		log.Fatalf("Invalid geothermal loops: %v", err)
+	}
+
+	categoryPairings := []data_structures.CategoryPairing{}
+	if categoryPairingsFile != "" {
+		pairings, err := internal.LoadCategoryPairings(categoryPairingsFile)
+		if err != nil {
+			log.Fatalf("Failed to load category pairings: %v", err)
+		}
+		categoryPairings = pairings
+		fmt.Printf("Loaded %d category pairings from %s\n\n", len(pairings), categoryPairingsFile)
+	}
+
+	matchOptions := data_structures.MatchOptions{
+		AllowedOrientations: allowedOrientations,
+		AHRIOutput:          ahriOutput,
+		PrimaryAHRI:         primaryAHRI,
+		VerifySystemTypes:   verifySystemTypes,
+		CategoryPairings:    categoryPairings,
+		Rejections:          stats.Rejections,
+	}
+	if err := internal.ValidateMatchOptions(matchOptions); err != nil {
+		log.Fatalf("Invalid match options: %v", err)
+	}
+
+	categoryRules := internal.DefaultCategoryRules()
+	if categoryRulesFile != "" {
+		rules, err := internal.LoadCategoryRules(categoryRulesFile)
+		if err != nil {
+			log.Fatalf("Failed to load category rules: %v", err)
+		}
+		categoryRules = rules
+		fmt.Printf("Loaded %d category rules from %s\n\n", len(rules), categoryRulesFile)
+	}
+
+	orientationRules := internal.DefaultOrientationRules()
+	if orientationRulesFile != "" {
+		rules, err := internal.LoadOrientationRules(orientationRulesFile)
+		if err != nil {
+			log.Fatalf("Failed to load orientation rules: %v", err)
+		}
+		orientationRules = rules
+		fmt.Printf("Loaded %d orientation rules from %s\n\n", len(rules), orientationRulesFile)
+	}
+
+	revisionRules := []data_structures.RevisionRule{}
+	if revisionRulesFile != "" {
+		rules, err := internal.LoadRevisionRules(revisionRulesFile)
+		if err != nil {
+			log.Fatalf("Failed to load revision rules: %v", err)
+		}
+		revisionRules = rules
+		fmt.Printf("Loaded %d revision rules from %s\n\n", len(rules), revisionRulesFile)
+		if outputLayoutFile == "" {
+			outputLayout.Columns = append(outputLayout.Columns, internal.RevisionColumns()...)
+		}
+	}
+
+	supersessions := []data_structures.Supersession{}
+	if supersessionsFile != "" {
+		loaded, err := internal.LoadSupersessions(supersessionsFile)
+		if err != nil {
+			log.Fatalf("Failed to load model cross-reference: %v", err)
+		}
+		supersessions = loaded
+		fmt.Printf("Loaded %d model supersessions from %s\n\n", len(loaded), supersessionsFile)
+		if outputLayoutFile == "" {
+			outputLayout.Columns = append(outputLayout.Columns, internal.SupersessionColumns()...)
+		}
+	}
+
 	fmt.Printf("Reading equipment headers...\n\n")
 	equipHeaders, err := internal.GetCSVHeader(csvFileEquip, equipmentFields)
 	if err != nil {
@@ -37,49 +263,78 @@ func main() {
 
 	// read and parse the equipment list csv starting after the headers have already been read:
 
+	stageStart := time.Now()
 	fmt.Printf("\nReading equipment list...\n\n")
 	equipmentList, err := internal.CSVEquipReader(csvFileEquip, equipHeaders)
 	if err != nil {
 		log.Fatalf("failed to read equipment csv file: %v", err)
 	}
 	fmt.Printf("Loaded %d pieces of equipment\n\n", len(equipmentList))
+	if err := internal.RecordInputFile(stats, csvFileEquip); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	internal.RecordStage(stats, "read equipment", stageStart)
 
 	// Figure out what different brands we are working with:
 
 	fmt.Printf("Identifying brands...\n\n")
 	brandMap := internal.BrandIdentify(equipmentList)
-	fmt.Printf("==== Brands (%d) ====\n\n", len(brandMap))
-	for k := range brandMap {
+	brands := internal.SortedBrands(brandMap)
+	fmt.Printf("==== Brands (%d) ====\n\n", len(brands))
+	for _, k := range brands {
 		fmt.Printf("%s\n", k)
 	}
 
+	matchGroups, err := internal.GroupBrands(brands, brandGroups)
+	if err != nil {
+		log.Fatalf("Invalid brand groups: %v", err)
+	}
+	for _, group := range matchGroups {
+		if len(group) > 1 {
+			fmt.Printf("\nMatching across brand group: %s\n", strings.Join(group, ", "))
+		}
+	}
+
 	// Normalize equipment:
+	stageStart = time.Now()
 	fmt.Printf("\nNormalizing equipment model #'s...\n\n")
 	for i := range equipmentList {
 		equipmentList[i] = internal.NormalizeString(equipmentList[i])
 	}
 	fmt.Printf("Equipment normalization complete!\n\n")
-	fmt.Printf("Categorizing equipment (standard vs communicating)...\n\n")
+	fmt.Printf("Categorizing equipment...\n\n")
 	for i := range equipmentList {
-		equipmentList[i] = internal.CategorizeEquipment(equipmentList[i])
+		equipmentList[i] = internal.CategorizeEquipment(equipmentList[i], categoryRules)
 	}
 	fmt.Printf("Equipment categorization complete!\n\n")
+	fmt.Printf("Deriving equipment orientation...\n\n")
+	for i := range equipmentList {
+		equipmentList[i] = internal.AssignOrientation(equipmentList[i], orientationRules)
+	}
+	fmt.Printf("Equipment orientation complete!\n\n")
+	internal.RecordEquipment(stats, equipmentList)
+	internal.RecordStage(stats, "prepare equipment", stageStart)
+
+	// Roles the default layout doesn't cover, such as mini-split indoor heads and packaged units, are reported in columns of their own
+	if outputLayoutFile == "" {
+		outputLayout.Columns = append(outputLayout.Columns, internal.RoleColumns(equipmentList)...)
+	}
+	if outputLayoutFile == "" && internal.HasWaterToAirUnits(equipmentList) {
+		outputLayout.Columns = append(outputLayout.Columns, waterLoopColumns...)
+	}
 
 	// Optional: Add some logging to show categorization results
-	standardCount := 0
-	communicatingCount := 0
+	categoryCounts := make(map[string]int)
 	for _, equip := range equipmentList {
-		if equip.Category == data_structures.CategoryStandard {
-			standardCount++
-		} else if equip.Category == data_structures.CategoryCommunicating {
-			communicatingCount++
-		}
+		categoryCounts[equip.Category]++
+	}
+	for _, category := range internal.EquipmentCategories(equipmentList) {
+		fmt.Printf("%s equipment: %d\n", category, categoryCounts[category])
 	}
-	fmt.Printf("Standard equipment: %d\n", standardCount)
-	fmt.Printf("Communicating equipment: %d\n\n", communicatingCount)
+	fmt.Printf("\n")
 	fmt.Printf("First 5 pieces:\n\n")
 
-	for i := 0; i < 5; i++ {
+	for i := 0; i < min(5, len(equipmentList)); i++ {
 		fmt.Printf("Equipment type: %v\nEquipment Input Model #: %v\nEquipment Normalized Model #: %v\nEquipment brand: %v\n\n\n",
 			equipmentList[i].Type,
 			equipmentList[i].InputModelNumber,
@@ -88,15 +343,21 @@ func main() {
 	}
 
 	// Read and parse ahri certified matches:
+	stageStart = time.Now()
 	fmt.Printf("Reading ahri certified matches...\n\n")
 	ahriList, err := internal.CSVAHRIReader(csvFileAHRI)
 	if err != nil {
 		log.Fatalf("Failed to read ahri csv file: %v", err)
 	}
 	fmt.Printf("Loaded %d ahri records\n\n", len(ahriList))
+	stats.AHRIRecordsLoaded = len(ahriList)
+	if err := internal.RecordInputFile(stats, csvFileAHRI); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	internal.RecordStage(stats, "read ahri", stageStart)
 
 	fmt.Printf("First 5 records:\n\n")
-	for i := 0; i < 5; i++ {
+	for i := 0; i < min(5, len(ahriList)); i++ {
 		fmt.Printf("Outdoor Unit: \n%v\n\nIndoor Unit: \n%v\n\nFurnace: \n%v\n\nAHRI Number: %v\n\n\n",
 			ahriList[i].OutdoorUnit,
 			ahriList[i].IndoorUnit,
@@ -106,94 +367,305 @@ func main() {
 
 	// Build the ahri lookup match for equipment config certification:
 
-	fmt.Printf("Building ahri cert lookup map...\n\n")
-	ahriMap := internal.BuildAHRIMap(ahriList)
-	fmt.Printf("Built ahri map with %d entries (including wildcard expansions)\n\n", len(ahriMap))
-	// for key, value := range ahriMap {
-	// 	fmt.Printf("Key: %v\nValue: %v\n\n\n", key, value)
-	// }
+	stageStart = time.Now()
+	fmt.Printf("Building ahri cert lookup index...\n\n")
+	ahriIndex, err := internal.BuildAHRIIndex(ahriList, data_structures.AHRIIndexOptions{
+		MultiWildcard:       multiCharWildcard,
+		Programs:            ahriPrograms,
+		ManufacturerAliases: manufacturerAliases,
+		RevisionRules:       revisionRules,
+		Supersessions:       supersessions,
+		AsOf:                runStart,
+	})
+	if err != nil {
+		log.Fatalf("Failed to build ahri index: %v", err)
+	}
+	for _, brand := range ahriIndex.UnpartitionedBrands(brands) {
+		log.Printf("No AHRI manufacturer matches brand %s: only records without a manufacturer certify it (add it to manufacturerAliases)", brand)
+	}
+	fmt.Printf("Built ahri index with %d entries (wildcards are resolved at lookup)\n\n", ahriIndex.Len())
+
+	// The certification engine finds the equipment each AHRI record certifies in this index
+	var stockIndex *internal.StockIndex
+	if matchEngine == internal.EngineCertification {
+		stockIndex, err = internal.BuildStockIndex(equipmentList, ahriIndex)
+		if err != nil {
+			log.Fatalf("Failed to index stocked equipment: %v", err)
+		}
+	}
+	stats.AHRIIndexEntries = ahriIndex.Len()
+	internal.RecordStage(stats, "build ahri index", stageStart)
+
+	if lookupRequest != nil {
+		request, err := internal.NewLookupRequest(equipmentList, lookupRequest.brand, lookupRequest.systemType,
+			lookupRequest.outdoor, lookupRequest.indoor, lookupRequest.furnace)
+		if err != nil {
+			log.Fatalf("lookup: %v", err)
+		}
+		lookup, err := internal.LookupCombination(context.Background(), request, equipmentList, matchGroups, ahriIndex, stockIndex, matchOptions, suggestionLimit)
+		printLookup(request, lookup, err)
+		return
+	}
 
 	// Process through each brand and system type separately:
+	stageStart = time.Now()
 	fmt.Printf("Generating equipment combo's and finding matches...\n\n")
 
 	allCertifiedMatches := make([]data_structures.OutputCSV, 0)
-	systemTypes := []string{
-		"central ac",
-		"furnace",
-		"central ac & air handler",
-		"central ac & furnace",
-		"heat pump & air handler",
-		"heat pump & furnace",
-	}
+	// Every registered system type is matched, in registration order
+	systemTypes := internal.SystemTypeNames()
 	totalCombinations := 0
 
-	for brand := range brandMap {
-		fmt.Printf("Processing brand: %s\n\n", brand)
+	ctx := context.Background()
+	if matchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, matchTimeout)
+		defer cancel()
+	}
 
-		brandEquipment := internal.EquipmentSort(equipmentList, brand)
-		fmt.Printf("   Found %d pieces of equipment for %s\n\n", len(brandEquipment), brand)
+	jobs := internal.BuildMatchJobs(equipmentList, matchGroups, systemTypes)
 
-		for _, sysType := range systemTypes {
-			combo, err := internal.GenerateFullSystemEquipmentConfig(brandEquipment, sysType)
-			if err != nil {
-				log.Printf("   Warning: Error generating %s combinations for %s: %v", sysType, brand, err)
+	if streamOutput {
+		fmt.Printf("Streaming certified matches to %s...\n\n", outputFilename)
+
+		err := internal.WriteOutputStream(outputLayout, outputFilename, func(emit func(data_structures.OutputCSV) error) error {
+			currentBrand := ""
+			for _, job := range jobs {
+				if job.Brand != currentBrand {
+					currentBrand = job.Brand
+					fmt.Printf("Processing brand: %s\n\n", job.Brand)
+				}
+
+				result := data_structures.MatchResult{Job: job}
+				err := internal.StreamMatchJob(ctx, job, matchEngine, ahriIndex, stockIndex, matchOptions, &result, func(match data_structures.OutputCSV) error {
+					internal.RecordMatch(stats, match)
+					return emit(match)
+				})
+				if err != nil {
+					// Generation errors are raised before anything is streamed; anything later is fatal
+					if result.Combinations == 0 && ctx.Err() == nil {
+						log.Printf("   Warning: Error generating %s combinations for %s: %v", job.SystemType, job.Brand, err)
+						continue
+					}
+					return err
+				}
+
+				if result.Combinations > 0 {
+					fmt.Printf("   Checked %d combinations for %s\n\n", result.Combinations, job.SystemType)
+				}
+				totalCombinations += result.Combinations
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("Failed to stream certified matches: %v", err)
+		}
+	} else {
+		results, err := internal.RunMatchJobs(ctx, jobs, matchEngine, ahriIndex, stockIndex, matchOptions, matchWorkers)
+		if err != nil {
+			log.Fatalf("Matching did not finish: %v", err)
+		}
+
+		// Report per brand and system type in job order so the log reads the same as a sequential run
+		currentBrand := ""
+		for _, result := range results {
+			brand := result.Job.Brand
+			sysType := result.Job.SystemType
+
+			if brand != currentBrand {
+				currentBrand = brand
+				fmt.Printf("Processing brand: %s\n\n", brand)
+				fmt.Printf("   Found %d pieces of equipment for %s\n\n", len(result.Job.Equipment), brand)
+			}
+
+			if result.Err != nil && result.Combinations == 0 {
+				log.Printf("   Warning: Error generating %s combinations for %s: %v", sysType, brand, result.Err)
 				continue
 			}
 
-			if len(combo) == 0 {
+			if result.Combinations == 0 {
 				continue
 			}
 
-			fmt.Printf("   Generated %d combinations for %s\n\n", len(combo), sysType)
-			totalCombinations += len(combo)
+			fmt.Printf("   Generated %d combinations for %s\n\n", result.Combinations, sysType)
+			totalCombinations += result.Combinations
 
-			fmt.Printf("Number of combo's: %d\n", len(combo))
+			fmt.Printf("Number of combo's: %d\n", result.Combinations)
 			fmt.Printf("First 5 combo's:\n\n")
-			for i := 0; i < 5; i++ {
+			for i, combo := range result.Sample {
 				fmt.Printf("Combo %d:\nOutdoor Unit: \n%v\n\nIndoor Unit: \n%v\n\nFurnace: \n%v\n\nSystem Type: %v\n\n\n",
 					i+1,
-					combo[i].OutdoorUnit,
-					combo[i].IndoorUnit,
-					combo[i].Furnace,
-					combo[i].SystemType)
+					combo.OutdoorUnit,
+					combo.IndoorUnit,
+					combo.Furnace,
+					combo.SystemType)
 			}
 
-			certifiedMatches, err := internal.FindCertifiedMatches(combo, ahriMap)
-			if err != nil {
-				log.Printf("   Warning: Error finding matches for %s: %v", sysType, err)
+			if result.Err != nil {
+				log.Printf("   Warning: Error finding matches for %s: %v", sysType, result.Err)
 				continue
 			}
 
-			if len(certifiedMatches) >= 0 {
-				fmt.Printf("   + Found %d certified matches for %s\n\n", len(certifiedMatches), sysType)
-				allCertifiedMatches = append(allCertifiedMatches, certifiedMatches...)
-			}
+			fmt.Printf("   + Found %d certified matches for %s\n\n", len(result.Matches), sysType)
+			allCertifiedMatches = append(allCertifiedMatches, result.Matches...)
 		}
+		internal.RecordMatches(stats, allCertifiedMatches)
 	}
+	internal.RecordStage(stats, "matching", stageStart)
+	stats.CombinationsChecked = totalCombinations
+
 	// Generate report on results:
 	separator := strings.Repeat("=", 60)
 	fmt.Printf("\n%s\n", separator)
 	fmt.Printf("SUMMARY\n")
 	fmt.Printf("%s\n", separator)
 	fmt.Printf("Total combinations checked: %d\n", totalCombinations)
-	fmt.Printf("Total certified matches found: %d\n", len(allCertifiedMatches))
+	fmt.Printf("Total certified matches found: %d\n", stats.CertifiedMatches)
+	if stats.HeuristicMatches > 0 {
+		fmt.Printf("Heuristic matches (no AHRI lookup): %d\n", stats.HeuristicMatches)
+	}
+	if stats.TonnageCompatible > 0 {
+		fmt.Printf("Tonnage-compatible, not certified: %d\n", stats.TonnageCompatible)
+	}
 
 	if totalCombinations > 0 {
-		matchRate := float64(len(allCertifiedMatches)) / float64(totalCombinations) * 100
+		matchRate := float64(stats.CertifiedMatches) / float64(totalCombinations) * 100
 		fmt.Printf("Match rate: %.2f%%\n", matchRate)
 	}
 	// Create final csv output:
-	if len(allCertifiedMatches) > 0 {
-		outputFilename := "C:/Users/mrich/OneDrive/Wilson/wilson_hvac_matches/certified_hvac_matches.csv"
-		fmt.Printf("\nWriting certified matches to %s...\n\n", outputFilename)
+	stageStart = time.Now()
+	if streamOutput {
+		fmt.Printf("\n✓ Complete! Certified matches have been streamed to %s\n", outputFilename)
+	} else if len(allCertifiedMatches) > 0 {
+		if err := internal.SortMatches(allCertifiedMatches, outputSortKeys); err != nil {
+			log.Fatalf("Failed to sort certified matches: %v", err)
+		}
 
-		err = internal.WriteOutputCSV(allCertifiedMatches, outputFilename)
-		if err != nil {
-			log.Fatalf("Failed to write output csv: %v", err)
+		if splitByBrand || splitBySystemType {
+			fmt.Printf("\nWriting certified matches to %s...\n\n", outputDirectory)
+
+			files, err := internal.WriteSplitOutput(allCertifiedMatches, outputLayout, outputDirectory, splitByBrand, splitBySystemType)
+			if err != nil {
+				log.Fatalf("Failed to write output files: %v", err)
+			}
+			for _, file := range files {
+				fmt.Printf("   %s (%d matches)\n", file.Filename, file.Rows)
+			}
+			fmt.Printf("\n✓ Complete! %d files and %s have been written to %s\n", len(files), internal.IndexFilename, outputDirectory)
+		} else {
+			fmt.Printf("\nWriting certified matches to %s...\n\n", outputFilename)
+
+			err = internal.WriteOutputLayout(allCertifiedMatches, outputLayout, outputFilename)
+			if err != nil {
+				log.Fatalf("Failed to write output csv: %v", err)
+			}
+			fmt.Printf("\n✓ Complete! Certified matches have been written to %s\n", outputFilename)
+		}
+
+		if jsonOutputFilename != "" {
+			if err := internal.WriteOutputJSON(allCertifiedMatches, jsonOutputFilename); err != nil {
+				log.Fatalf("Failed to write output json: %v", err)
+			}
+			fmt.Printf("\n✓ Certified matches and their provenance have been written to %s\n", jsonOutputFilename)
+		}
+
+		if writeMatrix {
+			matrices := internal.BuildCompatibilityMatrices(allCertifiedMatches)
+			files, err := internal.WriteMatrixCSV(matrices, matrixDirectory)
+			if err != nil {
+				log.Fatalf("Failed to write compatibility matrix csv: %v", err)
+			}
+			htmlFilename := matrixDirectory + "/compatibility_matrix.html"
+			if err := internal.WriteMatrixHTML(matrices, htmlFilename); err != nil {
+				log.Fatalf("Failed to write compatibility matrix html: %v", err)
+			}
+			fmt.Printf("\n✓ Wrote %d compatibility matrices to %s (and %s)\n", len(files), matrixDirectory, htmlFilename)
 		}
-		fmt.Printf("\n✓ Complete! Certified matches have been written to %s\n", outputFilename)
 		fmt.Println("\nYou can now open this file in Excel or any spreadsheet program to view your results.")
 	} else {
 		fmt.Println("\nNo certified matches found. No output file generated.")
 	}
+	internal.RecordStage(stats, "write output", stageStart)
+
+	if suggestionRequestsFile != "" {
+		requests, err := internal.LoadSuggestionRequests(suggestionRequestsFile, equipmentList)
+		if err != nil {
+			log.Fatalf("Failed to load suggestion requests: %v", err)
+		}
+
+		fmt.Printf("\n%s\n", separator)
+		fmt.Printf("SUGGESTIONS\n")
+		fmt.Printf("%s\n", separator)
+		for _, request := range requests {
+			lookup, err := internal.LookupCombination(ctx, request, equipmentList, matchGroups, ahriIndex, stockIndex, matchOptions, suggestionLimit)
+			printLookup(request, lookup, err)
+		}
+	}
+
+	// Stats are written even when nothing matched so a sudden drop can be alerted on
+	if statsFilename != "" {
+		internal.RecordStage(stats, "total", runStart)
+		if err := internal.WriteStatsJSON(stats, statsFilename); err != nil {
+			log.Fatalf("Failed to write run stats: %v", err)
+		}
+		fmt.Printf("\nRun statistics have been written to %s\n", statsFilename)
+	}
+}
+
+// lookupArgs is a single system given to the lookup command
+type lookupArgs struct {
+	brand      string
+	systemType string
+	outdoor    string
+	indoor     string
+	furnace    string
+}
+
+func parseLookupArgs(args []string) (lookupArgs, error) {
+	var lookup lookupArgs
+	flags := flag.NewFlagSet("lookup", flag.ContinueOnError)
+	flags.StringVar(&lookup.brand, "brand", "", "equipment brand")
+	flags.StringVar(&lookup.systemType, "type", "", `system type, e.g. "heat pump & furnace"`)
+	flags.StringVar(&lookup.outdoor, "outdoor", "", "outdoor unit model number")
+	flags.StringVar(&lookup.indoor, "indoor", "", "indoor unit model number (coil or air handler)")
+	flags.StringVar(&lookup.furnace, "furnace", "", "furnace model number, for systems with one")
+	if err := flags.Parse(args); err != nil {
+		return lookupArgs{}, err
+	}
+
+	if lookup.brand == "" || lookup.systemType == "" || lookup.outdoor == "" {
+		return lookupArgs{}, fmt.Errorf("-brand, -type and -outdoor are required")
+	}
+	return lookup, nil
+}
+
+// printLookup reports whether a requested system is certified, or its closest alternatives
+func printLookup(request data_structures.ComponentKey, lookup data_structures.LookupResult, err error) {
+	fmt.Printf("\n%s: %s / %s / %s\n", request.SystemType,
+		request.OutdoorUnit.InputModelNumber,
+		request.IndoorUnit.InputModelNumber,
+		request.Furnace.InputModelNumber)
+
+	if err != nil {
+		log.Printf("   Warning: Error finding suggestions: %v", err)
+		return
+	}
+	if len(lookup.Records) > 0 {
+		fmt.Printf("   Certified (AHRI %s)\n", lookup.Records[0].AHRINumber)
+		return
+	}
+	if len(lookup.Suggestions) == 0 {
+		fmt.Printf("   Not certified, and no close alternative is stocked\n")
+		return
+	}
+	fmt.Printf("   Not certified. Closest certified alternatives:\n")
+	for _, suggestion := range lookup.Suggestions {
+		fmt.Printf("   - AHRI %s: %s / %s / %s (changed %s, distance %d)\n",
+			suggestion.Match.AHRINumber,
+			suggestion.Combo.OutdoorUnit.InputModelNumber,
+			suggestion.Combo.IndoorUnit.InputModelNumber,
+			suggestion.Combo.Furnace.InputModelNumber,
+			strings.Join(suggestion.Changed, ", "),
+			suggestion.Distance)
+	}
 }